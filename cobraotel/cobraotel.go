@@ -4,9 +4,18 @@ package cobraotel
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/jzelinskie/cobrautil/v2"
@@ -16,13 +25,11 @@ import (
 	"go.opentelemetry.io/contrib/propagators/b3"
 	"go.opentelemetry.io/contrib/propagators/ot"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 // Option is function used to configure OpenTelemetry within a Cobra RunFunc.
@@ -40,6 +47,12 @@ func New(serviceName string, opts ...Option) *Builder {
 		serviceName: stringz.DefaultEmpty(serviceName, bi.Main.Path),
 		preRunLevel: 0,
 		logger:      logr.Discard(),
+		now:         time.Now,
+		opts:        append([]Option(nil), opts...),
+
+		resourceTimeout:    defaultResourceTimeout,
+		startupRetryJitter: fullJitter,
+		sleep:              sleepContext,
 	}
 	for _, configure := range opts {
 		configure(b)
@@ -47,18 +60,175 @@ func New(serviceName string, opts ...Option) *Builder {
 	return b
 }
 
+// Child returns a new Builder for a subcommand, configured like b with the
+// provided options applied on top, e.g. WithServiceName to override the
+// default service name.
+//
+// Registering the flags of the child on the subcommand, e.g. with
+// RegisterFlags(sub.Flags()), shadows the persistent flags registered by b
+// on a parent command: the subcommand is configured by the flags provided
+// on the command line, defaulting to the configuration of the child.
+//
+// Only configuration is inherited: the child does not share the tracer
+// provider configured by b.
+func (b *Builder) Child(opts ...Option) *Builder {
+	inherited := append(append([]Option(nil), b.opts...), opts...)
+	return New(b.serviceName, inherited...)
+}
+
 // Builder is used to configure OpenTelemetry via Cobra.
 type Builder struct {
 	flagPrefix  string
 	serviceName string
 	logger      logr.Logger
 	preRunLevel int
+	shutdownCtx context.Context
+	wrapper     func(trace.SpanExporter) trace.SpanExporter
+	envAttrs    map[string]string
+	detectors   []resource.Detector
+	noPropagate bool
+	opts        []Option
+
+	defaultEndpointFile   string
+	enabledFlag           string
+	userAgent             string
+	now                   func() time.Time
+	headersEnvVar         string
+	disableLegacyFlags    bool
+	sampler               trace.Sampler
+	setupTimeout          time.Duration
+	startupRetryJitter    func(delay time.Duration) time.Duration
+	sleep                 func(ctx context.Context, d time.Duration) error
+	serviceNameEnvVars    []string
+	serviceNameArg        int
+	serviceNameFromArg    bool
+	serviceNameFunc       func() string
+	serviceNameSanitizer  func(string) string
+	defaultInsecure       bool
+	insecureLocalhost     bool
+	argsRedactor          func(args []string) []string
+	droppedSpanCallback   func(count int)
+	backpressureThreshold int
+	backpressureCallback  func(failures int, err error)
+	exportErrorHandler    func(err error)
+	spanAttrs             []attribute.KeyValue
+	spanProcessors        []trace.SpanProcessor
+	spanProcessorOrder    SpanProcessorOrder
+	keepSlowSpans         time.Duration
+	extractPropagators    []string
+	injectPropagators     []string
+	lenientExtraction     bool
+	configuredSpan        bool
+	resourceOpts          []resource.Option
+	resourceTimeout       time.Duration
+	resourceJSON          []byte
+	respectExistingGlobal bool
+	installNoopOnNone     bool
+	commandSpanOpts       []oteltrace.SpanStartOption
+	exportOnPanic         bool
+	traceStateKey         string
+	traceStateValue       string
+	scopeName             string
+
+	// configureMu serializes Configure, such that the global tracer provider
+	// is always the last one configured.
+	configureMu sync.Mutex
+
+	// mu guards the configured tracer provider and its state, which
+	// Configure may replace and WithShutdownOnContext may shut down
+	// concurrently with their use.
+	mu             sync.Mutex
+	tracerProvider *trace.TracerProvider
+	shutdown       *shutdownState
+	resource       *resource.Resource
+	exportStats    *exportStats
+	commandSpan    oteltrace.Span
+
+	// stopShutdownWatch stops the goroutine shutting down tracerProvider
+	// once the context provided to WithShutdownOnContext is done.
+	stopShutdownWatch chan struct{}
+}
+
+// shutdownState records the result of shutting down a tracer provider, which
+// only happens once, and how long to wait for it.
+type shutdownState struct {
+	once sync.Once
+	err  error
+
+	// timeout and dropOnTimeout are resolved from flags by RunE(); see
+	// Shutdown.
+	timeout       time.Duration
+	dropOnTimeout bool
 }
 
 func (b *Builder) prefix(s string) string {
 	return cobrautil.PrefixJoiner(b.flagPrefix)(s)
 }
 
+// FlagGroup is a bitmask used to select which flags are registered by
+// RegisterFlagsWithOptions.
+type FlagGroup uint
+
+const (
+	// FlagProvider selects the "$PREFIX-provider" flag.
+	FlagProvider FlagGroup = 1 << iota
+
+	// FlagEndpoint selects the "$PREFIX-endpoint", "$PREFIX-endpoint-file",
+	// "$PREFIX-otlp-traces-path", "$PREFIX-headers", "$PREFIX-headers-file"
+	// and "$PREFIX-strict" flags.
+	FlagEndpoint
+
+	// FlagServiceName selects the "$PREFIX-service-name" flag.
+	FlagServiceName
+
+	// FlagTracePropagator selects the "$PREFIX-trace-propagator" flag.
+	FlagTracePropagator
+
+	// FlagInsecure selects the "$PREFIX-insecure" flag.
+	FlagInsecure
+
+	// FlagSampling selects the "$PREFIX-sampler", "$PREFIX-sample-ratio",
+	// "$PREFIX-sampling-ignore-parent", "$PREFIX-sampling-rules",
+	// "$PREFIX-max-spans-per-second" and "$PREFIX-ignore-attributes" flags.
+	FlagSampling
+
+	// FlagLegacy selects the hidden, deprecated "otel-jaeger-*" flags.
+	FlagLegacy
+
+	// FlagTLS selects the "$PREFIX-tls-*" and "$PREFIX-require-ca" flags.
+	FlagTLS
+
+	// FlagResource selects the flags controlling the attributes of the
+	// resource attached to every span, such as "$PREFIX-tag-build-info".
+	FlagResource
+
+	// FlagExport selects the flags controlling how spans are exported, such
+	// as "$PREFIX-processor".
+	FlagExport
+
+	// FlagCommand selects the "$PREFIX-trace-command" flag.
+	FlagCommand
+
+	// FlagDebug selects the "$PREFIX-debug" flag.
+	FlagDebug
+
+	// FlagSpanLimits selects the "$PREFIX-span-*-limit" flags.
+	FlagSpanLimits
+
+	// FlagsAll selects every flag.
+	FlagsAll = FlagProvider | FlagEndpoint | FlagServiceName | FlagTracePropagator | FlagInsecure | FlagSampling | FlagLegacy | FlagTLS | FlagResource | FlagExport | FlagCommand | FlagDebug | FlagSpanLimits
+)
+
+const (
+	defaultProvider          = "none"
+	defaultTracePropagator   = "w3c"
+	defaultSampleRatio       = 0.01
+	defaultProcessor         = "batch"
+	defaultConnectTimeout    = 10 * time.Second
+	defaultResourceTimeout   = 5 * time.Second
+	defaultStartupRetryDelay = time.Second
+)
+
 // RegisterFlags adds flags for configuring OpenTelemetry.
 //
 // The following flags are added:
@@ -66,24 +236,216 @@ func (b *Builder) prefix(s string) string {
 // - "$PREFIX-trace-propagator"
 // - "$PREFIX-insecure"
 // - "$PREFIX-endpoint"
+// - "$PREFIX-endpoint-file"
+// - "$PREFIX-otlp-traces-path"
+// - "$PREFIX-headers"
+// - "$PREFIX-headers-file"
+// - "$PREFIX-strict"
 // - "$PREFIX-service-name"
+// - "$PREFIX-sampler"
+// - "$PREFIX-sample-ratio"
+// - "$PREFIX-sampling-ignore-parent"
+// - "$PREFIX-sampling-rules"
+// - "$PREFIX-max-spans-per-second"
+// - "$PREFIX-ignore-attributes"
+// - "$PREFIX-tls-insecure-skip-verify"
+// - "$PREFIX-tls-server-name"
+// - "$PREFIX-require-ca"
+// - "$PREFIX-connect-timeout"
+// - "$PREFIX-startup-retries"
+// - "$PREFIX-startup-retry-delay"
+// - "$PREFIX-processor"
+// - "$PREFIX-batch-block-on-full"
+// - "$PREFIX-max-export-batch-size"
+// - "$PREFIX-shutdown-timeout"
+// - "$PREFIX-shutdown-drop-on-timeout"
+// - "$PREFIX-otlphttp-max-payload-bytes"
+// - "$PREFIX-export-errors-only"
+// - "$PREFIX-export-min-duration"
+// - "$PREFIX-tag-build-info"
+// - "$PREFIX-tag-process-start-time"
+// - "$PREFIX-semconv-version"
+// - "$PREFIX-resource-json"
+// - "$PREFIX-trace-command"
+// - "$PREFIX-debug"
+// - "$PREFIX-span-attribute-count-limit"
+// - "$PREFIX-span-attribute-value-length-limit"
+// - "$PREFIX-span-event-count-limit"
+// - "$PREFIX-span-link-count-limit"
 func (b *Builder) RegisterFlags(flags *pflag.FlagSet) {
-	flags.String(b.prefix("provider"), "none", `OpenTelemetry provider for tracing ("none", "otlphttp", "otlpgrpc")`)
-	flags.String(b.prefix("endpoint"), "", "OpenTelemetry collector endpoint - the endpoint can also be set by using enviroment variables")
-	flags.String(b.prefix("service-name"), b.serviceName, "service name for trace data")
-	flags.String(b.prefix("trace-propagator"), "w3c", `OpenTelemetry trace propagation format ("b3", "w3c", "ottrace"). Add multiple propagators separated by comma.`)
-	flags.Bool(b.prefix("insecure"), false, `connect to the OpenTelemetry collector in plaintext`)
-	flags.Float64(b.prefix("sample-ratio"), 0.01, "ratio of traces that are sampled")
+	b.RegisterFlagsWithOptions(flags, FlagsAll)
+}
+
+// RegisterFlagsWithOptions adds only the flags selected by the provided
+// FlagGroup.
+//
+// Flags that are not registered use their default values in RunE(). Flags
+// that already exist in the FlagSet are skipped, so it is safe to register
+// flags on the same FlagSet more than once, e.g. a FlagSet shared between
+// commands.
+func (b *Builder) RegisterFlagsWithOptions(target *pflag.FlagSet, groups FlagGroup) {
+	if b.disableLegacyFlags {
+		groups &^= FlagLegacy
+	}
+
+	// Flags are defined on a separate FlagSet because defining a flag twice
+	// panics; AddFlagSet only adds the flags missing from the target.
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+
+	if groups&FlagProvider != 0 {
+		flags.String(b.prefix("provider"), defaultProvider, `OpenTelemetry provider for tracing ("none", "otlphttp", "otlpgrpc", "otlp" to select it from OTEL_EXPORTER_OTLP_PROTOCOL, or "auto" to infer it from the endpoint). Add multiple providers separated by "+" to export to each of them.`)
+	}
+	if groups&FlagEndpoint != 0 {
+		flags.String(b.prefix("endpoint"), "", "OpenTelemetry collector endpoint - the endpoint can also be set by using enviroment variables. Add multiple endpoints separated by comma to fail over between them, and separate the endpoints of multiple providers by \"+\".")
+		flags.String(b.prefix("endpoint-file"), b.defaultEndpointFile, "local path to a file containing the OpenTelemetry collector endpoint, used when no endpoint is provided")
+		flags.String(b.prefix("otlp-traces-path"), "", `URL path used to export traces with the "otlphttp" provider (default "/v1/traces")`)
+		flags.StringToString(b.prefix("headers"), nil, `headers sent to the OpenTelemetry collector (e.g. "api-key=secret")`)
+		flags.String(b.prefix("headers-file"), "", `local path to a file containing headers sent to the OpenTelemetry collector, one "key=value" per line`)
+		flags.Bool(b.prefix("strict"), false, "require an endpoint to be configured instead of defaulting to a local collector")
+	}
+	if groups&FlagServiceName != 0 {
+		flags.String(b.prefix("service-name"), b.serviceName, "service name for trace data")
+	}
+	if groups&FlagTracePropagator != 0 {
+		flags.String(b.prefix("trace-propagator"), defaultTracePropagator, `OpenTelemetry trace propagation format ("b3", "w3c", "ottrace"). Add multiple propagators separated by comma.`)
+	}
+	if groups&FlagInsecure != 0 {
+		flags.Bool(b.prefix("insecure"), b.defaultInsecure, `connect to the OpenTelemetry collector in plaintext`)
+	}
+	if groups&FlagSampling != 0 {
+		flags.String(b.prefix("sampler"), "", `sampler used for traces, overriding the ratio-based sampling flags ("always_on", "always_off", "traceidratio", "parentbased_always_on", "parentbased_always_off", "parentbased_traceidratio")`)
+		flags.Float64(b.prefix("sample-ratio"), defaultSampleRatio, "ratio of traces that are sampled")
+		flags.Bool(b.prefix("sampling-ignore-parent"), false, "sample by ratio alone, ignoring the sampling decision of inbound requests")
+		flags.StringSlice(b.prefix("sampling-rules"), nil, `ratio of traces that are sampled for root spans with a given name, overriding the sample ratio (e.g. "checkout=1")`)
+		flags.Int(b.prefix("max-spans-per-second"), 0, "maximum number of root spans sampled per second (0 for no limit)")
+		flags.StringSlice(b.prefix("ignore-attributes"), nil, `drop spans started with any of these attributes (e.g. "http.target=/healthz")`)
+	}
+	if groups&FlagTLS != 0 {
+		flags.Bool(b.prefix("tls-insecure-skip-verify"), false, "connect to the OpenTelemetry collector over TLS without verifying its certificate (insecure)")
+		flags.String(b.prefix("tls-server-name"), "", "server name expected in the OpenTelemetry collector's TLS certificate, if it differs from the endpoint")
+		flags.Bool(b.prefix("require-ca"), false, "fail on startup unless CA certificates are configured to verify the OpenTelemetry collector over TLS (e.g. with OTEL_EXPORTER_OTLP_CERTIFICATE), such as for a collector using a private CA")
+	}
+	if groups&FlagResource != 0 {
+		flags.Bool(b.prefix("tag-build-info"), true, "add the service version and VCS revision of the binary to trace data")
+		flags.Bool(b.prefix("tag-process-start-time"), false, "add the start time of the process to trace data")
+		flags.String(b.prefix("semconv-version"), "", `semantic convention version used for the schema URL of trace data ("1.7.0", "1.17.0", "1.21.0"; defaults to the version of the OpenTelemetry SDK)`)
+		flags.String(b.prefix("resource-json"), "", `attributes added to trace data as a JSON object (e.g. '{"deployment.environment":"prod"}'), or a local path to a file containing one`)
+	}
+	if groups&FlagExport != 0 {
+		flags.Duration(b.prefix("connect-timeout"), defaultConnectTimeout, "maximum time spent creating the exporter on startup (0 for no limit); the OTLP exporters connect to the collector lazily")
+		flags.Int(b.prefix("startup-retries"), 0, "number of times creating the exporter is retried on startup when it fails, e.g. when the OpenTelemetry collector is not reachable yet")
+		flags.Duration(b.prefix("startup-retry-delay"), defaultStartupRetryDelay, "maximum delay between startup retries; the delay is randomized up to this value so that replicas starting at once do not retry at once")
+		flags.String(b.prefix("processor"), defaultProcessor, `span processor used to export spans ("batch", "simple")`)
+		flags.Bool(b.prefix("batch-block-on-full"), false, "block instead of dropping spans when the batch processor's queue is full")
+		flags.Int(b.prefix("max-export-batch-size"), 0, "maximum number of spans exported at once by the batch processor (0 for the default)")
+		flags.Duration(b.prefix("shutdown-timeout"), 0, "maximum time spent flushing spans on shutdown (0 for no limit)")
+		flags.Bool(b.prefix("shutdown-drop-on-timeout"), true, "drop unflushed spans once --"+b.prefix("shutdown-timeout")+" elapses instead of waiting for them to be exported")
+		flags.Int(b.prefix("otlphttp-max-payload-bytes"), 0, `approximate maximum size of the requests sent by the "otlphttp" provider, used to lower the maximum export batch size (0 for no limit)`)
+		flags.Bool(b.prefix("export-errors-only"), false, "only export spans with an error status")
+		flags.Duration(b.prefix("export-min-duration"), 0, "only export spans lasting at least this long (errors are also exported when combined with --"+b.prefix("export-errors-only")+")")
+	}
+	if groups&FlagCommand != 0 {
+		flags.Bool(b.prefix("trace-command"), false, "emit a span for the execution of the command")
+	}
+	if groups&FlagDebug != 0 {
+		flags.Bool(b.prefix("debug"), false, "log the resolved OpenTelemetry configuration and SDK diagnostics to stderr, e.g. to troubleshoot missing traces")
+	}
+	if groups&FlagSpanLimits != 0 {
+		flags.Int(b.prefix("span-attribute-count-limit"), 0, "maximum number of attributes per span (0 for OTEL_SPAN_ATTRIBUTE_COUNT_LIMIT or the SDK default, negative for no limit)")
+		flags.Int(b.prefix("span-attribute-value-length-limit"), 0, "maximum length of span attribute values (0 for OTEL_SPAN_ATTRIBUTE_VALUE_LENGTH_LIMIT or the SDK default, negative for no limit)")
+		flags.Int(b.prefix("span-event-count-limit"), 0, "maximum number of events per span (0 for OTEL_SPAN_EVENT_COUNT_LIMIT or the SDK default, negative for no limit)")
+		flags.Int(b.prefix("span-link-count-limit"), 0, "maximum number of links per span (0 for OTEL_SPAN_LINK_COUNT_LIMIT or the SDK default, negative for no limit)")
+	}
 
-	// Legacy flags! Will eventually be dropped!
-	flags.String("otel-jaeger-endpoint", "", "OpenTelemetry collector endpoint - the endpoint can also be set by using enviroment variables")
-	if err := flags.MarkHidden("otel-jaeger-endpoint"); err != nil {
-		panic("failed to mark flag hidden: " + err.Error())
+	if groups&FlagLegacy != 0 {
+		// Legacy flags! Will eventually be dropped!
+		flags.String("otel-jaeger-endpoint", "", "OpenTelemetry collector endpoint - the endpoint can also be set by using enviroment variables")
+		if err := flags.MarkHidden("otel-jaeger-endpoint"); err != nil {
+			panic("failed to mark flag hidden: " + err.Error())
+		}
+		flags.String("otel-jaeger-service-name", b.serviceName, "service name for trace data")
+		if err := flags.MarkHidden("otel-jaeger-service-name"); err != nil {
+			panic("failed to mark flag hidden: " + err.Error())
+		}
 	}
-	flags.String("otel-jaeger-service-name", b.serviceName, "service name for trace data")
-	if err := flags.MarkHidden("otel-jaeger-service-name"); err != nil {
-		panic("failed to mark flag hidden: " + err.Error())
+
+	target.AddFlagSet(flags)
+}
+
+// DeprecatedFlagMarker is appended to the names of deprecated flags returned
+// by FlagNames.
+const DeprecatedFlagMarker = " (deprecated)"
+
+// FlagNames returns the names of every flag that RegisterFlags adds for the
+// provided prefix, in registration order.
+//
+// Deprecated flags are suffixed with DeprecatedFlagMarker.
+func FlagNames(prefix string) []string {
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	flags.SortFlags = false
+	New("cobraotel", WithFlagPrefix(prefix)).RegisterFlags(flags)
+
+	var names []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Hidden {
+			names = append(names, f.Name+DeprecatedFlagMarker)
+			return
+		}
+		names = append(names, f.Name)
+	})
+	return names
+}
+
+// flagOrDefault returns the value of the named flag or the provided default
+// if the flag was never registered.
+//
+// get panics if the flag was registered with another type than the one
+// registered by RegisterFlags, which checkFlagTypes reports as an error
+// beforehand.
+func flagOrDefault[T any](cmd *cobra.Command, name string, def T, get func(*cobra.Command, string) T) T {
+	if cmd.Flags().Lookup(name) == nil {
+		return def
 	}
+	return get(cmd, name)
+}
+
+// flagTypeCheckers read a flag of each type registered by RegisterFlags,
+// returning an error if the flag has another type.
+var flagTypeCheckers = map[string]func(*cobra.Command, string) error{
+	"bool":           checkFlag(cobrautil.GetBool),
+	"duration":       checkFlag(cobrautil.GetDuration),
+	"float64":        checkFlag(cobrautil.GetFloat64),
+	"int":            checkFlag(cobrautil.GetInt),
+	"string":         checkFlag(cobrautil.GetString),
+	"stringSlice":    checkFlag(cobrautil.GetStringSlice),
+	"stringToString": checkFlag(cobrautil.GetStringToString),
+}
+
+func checkFlag[T any](get func(*cobra.Command, string) (T, error)) func(*cobra.Command, string) error {
+	return func(cmd *cobra.Command, name string) error {
+		_, err := get(cmd, name)
+		return err
+	}
+}
+
+// checkFlagTypes returns an error if any flag of cmd named like one of the
+// flags registered by RegisterFlags has another type, e.g. because the
+// command registered a flag of its own under that name, such that reading
+// the flags with flagOrDefault does not panic.
+func (b *Builder) checkFlagTypes(cmd *cobra.Command) error {
+	expected := pflag.NewFlagSet("", pflag.ContinueOnError)
+	b.RegisterFlags(expected)
+
+	var err error
+	expected.VisitAll(func(f *pflag.Flag) {
+		if err != nil || cmd.Flags().Lookup(f.Name) == nil {
+			return
+		}
+		if check, ok := flagTypeCheckers[f.Value.Type()]; ok {
+			err = check(cmd, f.Name)
+		}
+	})
+	return err
 }
 
 // RegisterFlagCompletion adds completion functions supported flags.
@@ -91,127 +453,879 @@ func (b *Builder) RegisterFlags(flags *pflag.FlagSet) {
 // The following flags are completed:
 // - "$PREFIX-provider"
 // - "$PREFIX-trace-propagator"
+// - "$PREFIX-sampler"
+//
+// Flags that were not registered on the command are skipped.
 func (b *Builder) RegisterFlagCompletion(cmd *cobra.Command) error {
-	if err := cmd.RegisterFlagCompletionFunc(b.prefix("provider"), func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return []string{"none", "otlphttp", "otlpgrpc"}, cobra.ShellCompDirectiveDefault
-	}); err != nil {
-		return err
+	if cmd.Flag(b.prefix("provider")) != nil {
+		if err := cmd.RegisterFlagCompletionFunc(b.prefix("provider"), func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return providerNames(), cobra.ShellCompDirectiveDefault
+		}); err != nil {
+			return err
+		}
 	}
 
-	if err := cmd.RegisterFlagCompletionFunc(b.prefix("trace-propagator"), func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return []string{"b3", "w3c", "ottrace"}, cobra.ShellCompDirectiveDefault
-	}); err != nil {
-		return err
+	if cmd.Flag(b.prefix("trace-propagator")) != nil {
+		if err := cmd.RegisterFlagCompletionFunc(b.prefix("trace-propagator"), func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return []string{"b3", "w3c", "ottrace"}, cobra.ShellCompDirectiveDefault
+		}); err != nil {
+			return err
+		}
+	}
+
+	if cmd.Flag(b.prefix("sampler")) != nil {
+		if err := cmd.RegisterFlagCompletionFunc(b.prefix("sampler"), func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return samplerNames, cobra.ShellCompDirectiveDefault
+		}); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// SkipAnnotation is the annotation that opts a command out of the
+// configuration performed by RunE() when set to "true".
+//
+//	cmd.Annotations = map[string]string{cobraotel.SkipAnnotation: "true"}
+const SkipAnnotation = "otel.skip"
+
 // RunE returns a Cobra run func that configures the
 // corresponding otel provider from a command.
 //
+// Builtin commands and commands annotated with SkipAnnotation are skipped.
+//
+// The Config read from flags is installed with Configure.
+//
 // The required flags can be added to a command by using
 // RegisterOpenTelemetryFlags().
 func (b *Builder) RunE() cobrautil.CobraRunFunc {
 	return func(cmd *cobra.Command, args []string) error {
-		if cobrautil.IsBuiltinCommand(cmd) {
+		if cobrautil.IsBuiltinCommand(cmd) || isCompletionCommand(cmd) {
 			return nil // No-op for builtins
 		}
+		if skip, _ := strconv.ParseBool(cmd.Annotations[SkipAnnotation]); skip {
+			return nil
+		}
 
-		provider := strings.ToLower(cobrautil.MustGetString(cmd, b.prefix("provider")))
-		serviceName := cobrautil.MustGetString(cmd, b.prefix("service-name"))
-		endpoint := cobrautil.MustGetString(cmd, b.prefix("endpoint"))
-		insecure := cobrautil.MustGetBool(cmd, b.prefix("insecure"))
-		propagators := strings.Split(cobrautil.MustGetString(cmd, b.prefix("trace-propagator")), ",")
-		sampleRatio := cobrautil.MustGetFloat64(cmd, b.prefix("sample-ratio"))
-		var noLogger logr.Logger
-		if b.logger != noLogger {
-			otel.SetLogger(b.logger)
-		}
-
-		var exporter trace.SpanExporter
-		var err error
-
-		// If endpoint is not set, the clients are configured via the OpenTelemetry environment variables or
-		// default values.
-		// See: https://github.com/open-telemetry/opentelemetry-go/tree/main/exporters/otlp/otlptrace#environment-variables
-		// or https://github.com/open-telemetry/opentelemetry-go/tree/main/exporters/jaeger#environment-variables
-		switch provider {
-		case "none":
-			// Nothing.
-		case "otlphttp":
-			var opts []otlptracehttp.Option
-			if endpoint != "" {
-				opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
-			}
-			if insecure {
-				opts = append(opts, otlptracehttp.WithInsecure())
-			}
-			exporter, err = otlptrace.New(context.Background(), otlptracehttp.NewClient(opts...))
+		if b.enabledFlag != "" {
+			enabled, err := cmd.Flags().GetBool(b.enabledFlag)
 			if err != nil {
-				return err
+				return fmt.Errorf("failed to read flag enabling opentelemetry: %w", err)
 			}
-
-			if err := initOtelTracer(exporter, serviceName, propagators, sampleRatio); err != nil {
-				return err
-			}
-		case "otlpgrpc":
-			var opts []otlptracegrpc.Option
-			if endpoint != "" {
-				opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
-			}
-			if insecure {
-				opts = append(opts, otlptracegrpc.WithInsecure())
+			if !enabled {
+				return nil
 			}
+		}
 
-			exporter, err = otlptrace.New(context.Background(), otlptracegrpc.NewClient(opts...))
+		cfg, err := b.configFromFlags(cmd, args)
+		if err != nil {
+			return err
+		}
+		if _, err := b.Configure(context.Background(), cfg); err != nil {
+			return err
+		}
+
+		if tracerProvider, _ := b.currentTracerProvider(); tracerProvider != nil && flagOrDefault(cmd, b.prefix("trace-command"), false, cobrautil.MustGetBool) {
+			b.startCommandSpan(cmd, args)
+		}
+		return nil
+	}
+}
+
+// configFromFlags reads the Config used by RunE() from flags and the
+// positional arguments of the command.
+func (b *Builder) configFromFlags(cmd *cobra.Command, args []string) (Config, error) {
+	if err := b.checkFlagTypes(cmd); err != nil {
+		return Config{}, err
+	}
+	if err := validateProviders(flagOrDefault(cmd, b.prefix("provider"), defaultProvider, cobrautil.MustGetString)); err != nil {
+		return Config{}, err
+	}
+	provider := b.providerFromFlags(cmd)
+	serviceName, serviceNameSet := b.resolveServiceName(cmd, args)
+	if !serviceNameSet {
+		serviceName = ""
+	}
+
+	rawEndpoint, err := b.endpointFromFlags(cmd)
+	if err != nil {
+		return Config{}, err
+	}
+	b.warnLegacyEndpoint(cmd)
+	if provider, err = b.resolveProvider(provider, rawEndpoint); err != nil {
+		return Config{}, err
+	}
+	if provider != "none" && rawEndpoint == "" && flagOrDefault(cmd, b.prefix("strict"), false, cobrautil.MustGetBool) && !endpointFromEnv() {
+		return Config{}, fmt.Errorf(
+			"--%s requires an endpoint for the %q provider: set --%s or %s",
+			b.prefix("strict"), provider, b.prefix("endpoint"), strings.Join(endpointEnvVars, " or "),
+		)
+	}
+	endpoint, err := normalizeEndpoints(rawEndpoint)
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid opentelemetry endpoint: %w", err)
+	}
+	insecure := b.insecureFromFlags(cmd, endpoint)
+
+	sampler, err := b.samplerFromFlags(cmd)
+	if err != nil {
+		return Config{}, err
+	}
+
+	headers, err := b.headersFromFlags(cmd)
+	if err != nil {
+		return Config{}, err
+	}
+
+	tlsConfig, err := b.tlsConfigFromFlags(cmd, insecure)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := b.requireCA(cmd, provider, insecure, tlsConfig); err != nil {
+		return Config{}, err
+	}
+
+	resourceAttrs, err := b.resourceAttributesFromFlags(cmd)
+	if err != nil {
+		return Config{}, err
+	}
+
+	// A connect timeout of zero disables it, which Config represents with a
+	// negative value.
+	connectTimeout := flagOrDefault(cmd, b.prefix("connect-timeout"), defaultConnectTimeout, cobrautil.MustGetDuration)
+	if connectTimeout == 0 {
+		connectTimeout = -1
+	}
+
+	maxExportBatchSize := flagOrDefault(cmd, b.prefix("max-export-batch-size"), 0, cobrautil.MustGetInt)
+	if provider == "otlphttp" {
+		maxPayloadBytes := flagOrDefault(cmd, b.prefix("otlphttp-max-payload-bytes"), 0, cobrautil.MustGetInt)
+		maxExportBatchSize = exportBatchSize(maxExportBatchSize, maxPayloadBytes)
+	}
+
+	return Config{
+		Provider:           provider,
+		Endpoint:           rawEndpoint,
+		URLPath:            flagOrDefault(cmd, b.prefix("otlp-traces-path"), "", cobrautil.MustGetString),
+		Insecure:           insecure,
+		Headers:            headers,
+		TLSConfig:          tlsConfig,
+		ServiceName:        serviceName,
+		ResourceAttributes: resourceAttrs,
+		ConnectTimeout:     connectTimeout,
+		StartupRetries:     flagOrDefault(cmd, b.prefix("startup-retries"), 0, cobrautil.MustGetInt),
+		StartupRetryDelay:  flagOrDefault(cmd, b.prefix("startup-retry-delay"), defaultStartupRetryDelay, cobrautil.MustGetDuration),
+		Propagators:        b.PropagatorNames(cmd),
+		Sampler:            sampler,
+		Processor:          strings.ToLower(flagOrDefault(cmd, b.prefix("processor"), defaultProcessor, cobrautil.MustGetString)),
+		BlockOnFull:        flagOrDefault(cmd, b.prefix("batch-block-on-full"), false, cobrautil.MustGetBool),
+		MaxExportBatchSize: maxExportBatchSize,
+		ExportErrorsOnly:   flagOrDefault(cmd, b.prefix("export-errors-only"), false, cobrautil.MustGetBool),
+		ExportMinDuration:  flagOrDefault(cmd, b.prefix("export-min-duration"), 0, cobrautil.MustGetDuration),
+		OmitBuildInfo:      !flagOrDefault(cmd, b.prefix("tag-build-info"), true, cobrautil.MustGetBool),
+		ProcessStartTime:   flagOrDefault(cmd, b.prefix("tag-process-start-time"), false, cobrautil.MustGetBool),
+		SemconvVersion:     flagOrDefault(cmd, b.prefix("semconv-version"), "", cobrautil.MustGetString),
+		Debug:              flagOrDefault(cmd, b.prefix("debug"), false, cobrautil.MustGetBool),
+		SpanLimits: SpanLimits{
+			AttributeCount:       flagOrDefault(cmd, b.prefix("span-attribute-count-limit"), 0, cobrautil.MustGetInt),
+			AttributeValueLength: flagOrDefault(cmd, b.prefix("span-attribute-value-length-limit"), 0, cobrautil.MustGetInt),
+			EventCount:           flagOrDefault(cmd, b.prefix("span-event-count-limit"), 0, cobrautil.MustGetInt),
+			LinkCount:            flagOrDefault(cmd, b.prefix("span-link-count-limit"), 0, cobrautil.MustGetInt),
+		},
+		ShutdownTimeout:       flagOrDefault(cmd, b.prefix("shutdown-timeout"), 0, cobrautil.MustGetDuration),
+		ShutdownWaitOnTimeout: !flagOrDefault(cmd, b.prefix("shutdown-drop-on-timeout"), true, cobrautil.MustGetBool),
+	}, nil
+}
+
+// setupError annotates errors caused by exceeding the timeout configured
+// with WithSetupTimeout.
+func setupError(ctx context.Context, err error) error {
+	switch {
+	case ctx.Err() == nil:
+		return err
+	case errors.Is(err, ctx.Err()):
+		return fmt.Errorf("opentelemetry setup did not complete in time: %w", err)
+	default:
+		return fmt.Errorf("opentelemetry setup did not complete in time: %w: %w", ctx.Err(), err)
+	}
+}
+
+// isCompletionCommand returns whether cmd is part of cobra's shell completion
+// machinery: the hidden "__complete" command invoked on every tab press and
+// the default "completion" command generating the scripts, neither of which
+// should wait on an exporter. IsBuiltinCommand only matches the latter by its
+// legacy "completion [command]" usage.
+func isCompletionCommand(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		switch c.Name() {
+		case cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+			return true
+		case "completion":
+			return c.HasParent() && c.Parent() == c.Root()
+		}
+	}
+	return false
+}
+
+// serviceNameFromFlags returns the configured service name and whether it
+// was explicitly provided rather than defaulted.
+//
+// When "$PREFIX-service-name" is not set, the environment variables provided
+// to WithServiceNameFromEnvFallback are consulted in order before falling
+// back to the default service name.
+func (b *Builder) serviceNameFromFlags(cmd *cobra.Command) (string, bool) {
+	if cmd.Flags().Changed(b.prefix("service-name")) {
+		return cobrautil.MustGetString(cmd, b.prefix("service-name")), true
+	}
+
+	for _, envVar := range b.serviceNameEnvVars {
+		if name := os.Getenv(envVar); name != "" {
+			b.logger.V(b.preRunLevel).Info("using service name from environment", "envVar", envVar, "service", name)
+			return name, true
+		}
+	}
+	return flagOrDefault(cmd, b.prefix("service-name"), b.serviceName, cobrautil.MustGetString), false
+}
+
+// resolveServiceName returns the service name for a run of cmd with the
+// provided positional arguments and whether it was explicitly provided rather
+// than defaulted.
+//
+// The service name is resolved in the following order:
+// 1. the name returned by the func provided to WithServiceNameFunc
+// 2. the "$PREFIX-service-name" flag
+// 3. the environment variables provided to WithServiceNameFromEnvFallback
+// 4. the argument selected by WithServiceNameFromArg
+// 5. the default service name
+func (b *Builder) resolveServiceName(cmd *cobra.Command, args []string) (string, bool) {
+	if b.serviceNameFunc != nil {
+		if name := b.serviceNameFunc(); name != "" {
+			return name, true
+		}
+	}
+	name, set := b.serviceNameFromFlags(cmd)
+	if !set && b.serviceNameFromArg && b.serviceNameArg >= 0 && b.serviceNameArg < len(args) {
+		return args[b.serviceNameArg], true
+	}
+	return name, set
+}
+
+// providerFromFlags returns the normalized name of the configured provider.
+func (b *Builder) providerFromFlags(cmd *cobra.Command) string {
+	return strings.ToLower(strings.TrimSpace(flagOrDefault(cmd, b.prefix("provider"), defaultProvider, cobrautil.MustGetString)))
+}
+
+// resolveProvider resolves the "auto" and "otlp" providers with
+// resolveProviders, logging the resolved provider.
+func (b *Builder) resolveProvider(provider, endpoint string) (string, error) {
+	resolved, err := resolveProviders(provider, endpoint)
+	if err != nil {
+		return "", err
+	}
+	if resolved != provider {
+		b.logger.V(b.preRunLevel).Info("resolved opentelemetry provider", "provider", resolved)
+	}
+	return resolved, nil
+}
+
+// Enabled returns whether tracing is configured for the provided command,
+// e.g. to skip building expensive span attributes when it is not.
+//
+// Tracing is disabled when the provider is "none", when the flag provided to
+// WithEnabledFlag is false, or when the OTEL_SDK_DISABLED environment
+// variable is "true". Enabled only reads flag values, so it is safe to call
+// before RunE().
+func (b *Builder) Enabled(cmd *cobra.Command) bool {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("OTEL_SDK_DISABLED")), "true") {
+		return false
+	}
+	if b.enabledFlag != "" {
+		if enabled, err := cmd.Flags().GetBool(b.enabledFlag); err != nil || !enabled {
+			return false
+		}
+	}
+	return b.providerFromFlags(cmd) != "none"
+}
+
+// endpointFromFlags returns the collector endpoint.
+//
+// The endpoint is resolved in the following order:
+// 1. the "$PREFIX-endpoint" flag
+// 2. the contents of the file at "$PREFIX-endpoint-file"
+// 3. OpenTelemetry environment variables or defaults, applied by the exporter
+//
+// A missing endpoint file is only an error if the flag was explicitly set.
+func (b *Builder) endpointFromFlags(cmd *cobra.Command) (string, error) {
+	if endpoint := flagOrDefault(cmd, b.prefix("endpoint"), "", cobrautil.MustGetString); endpoint != "" {
+		return endpoint, nil
+	}
+
+	path := flagOrDefault(cmd, b.prefix("endpoint-file"), b.defaultEndpointFile, cobrautil.MustGetStringExpanded)
+	if path == "" {
+		return "", nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !cmd.Flags().Changed(b.prefix("endpoint-file")) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read opentelemetry endpoint file: %w", err)
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// warnLegacyEndpoint logs a warning when both "$PREFIX-endpoint" and the
+// legacy "otel-jaeger-endpoint" flag were explicitly set to different values,
+// which is likely a misconfiguration: the legacy flag is ignored.
+func (b *Builder) warnLegacyEndpoint(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	if !flags.Changed(b.prefix("endpoint")) || !flags.Changed("otel-jaeger-endpoint") {
+		return
+	}
+
+	endpoint := cobrautil.MustGetString(cmd, b.prefix("endpoint"))
+	legacy := cobrautil.MustGetString(cmd, "otel-jaeger-endpoint")
+	if endpoint != legacy {
+		b.logger.V(b.preRunLevel).Info(
+			"WARNING: ignoring conflicting legacy --otel-jaeger-endpoint flag",
+			"endpoint", redactEndpoints(endpoint),
+			"legacyEndpoint", redactEndpoints(legacy),
+		)
+	}
+}
+
+// endpointEnvVars are the environment variables configuring the endpoint of
+// the OTLP exporters.
+var endpointEnvVars = []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"}
+
+// endpointFromEnv returns whether an endpoint is configured by one of
+// endpointEnvVars.
+func endpointFromEnv() bool {
+	for _, envVar := range endpointEnvVars {
+		if os.Getenv(envVar) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceAttributesFromFlags returns the resource attributes provided by
+// "$PREFIX-resource-json", either inline or read from a file.
+//
+// The value is parsed as inline JSON when it starts with "{".
+func (b *Builder) resourceAttributesFromFlags(cmd *cobra.Command) ([]attribute.KeyValue, error) {
+	value := strings.TrimSpace(flagOrDefault(cmd, b.prefix("resource-json"), "", cobrautil.MustGetString))
+	if value == "" {
+		return nil, nil
+	}
+
+	source := "--" + b.prefix("resource-json")
+	data := []byte(value)
+	if !strings.HasPrefix(value, "{") {
+		source = os.ExpandEnv(value)
+		contents, err := os.ReadFile(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read opentelemetry resource JSON file: %w", err)
+		}
+		data = contents
+	}
+
+	attrs, err := parseResourceJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource JSON in %s: %w", source, err)
+	}
+	return attrs, nil
+}
+
+// headersFromFlags returns the headers sent with every export request.
+//
+// Headers provided by the "$PREFIX-headers" flag take precedence over those
+// read from the file provided by "$PREFIX-headers-file", which take
+// precedence over those read from the environment variable provided to
+// WithHeadersFromEnv.
+func (b *Builder) headersFromFlags(cmd *cobra.Command) (map[string]string, error) {
+	headers := make(map[string]string)
+	if b.headersEnvVar != "" {
+		if value := os.Getenv(b.headersEnvVar); value != "" {
+			envHeaders, err := parseHeaders(value)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("invalid headers in %s: %w", b.headersEnvVar, err)
 			}
-
-			if err := initOtelTracer(exporter, serviceName, propagators, sampleRatio); err != nil {
-				return err
+			for k, v := range envHeaders {
+				headers[k] = v
 			}
-		default:
-			return fmt.Errorf("unknown tracing provider: %s", provider)
 		}
+	}
 
-		b.logger.V(b.preRunLevel).Info(
-			"configured opentelemetry tracing",
-			"provider", provider,
-			"endpoint", endpoint,
-			"service", serviceName,
-			"insecure", insecure,
-			"sampleRatio", sampleRatio,
+	if path := flagOrDefault(cmd, b.prefix("headers-file"), "", cobrautil.MustGetStringExpanded); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read opentelemetry headers file: %w", err)
+		}
+		fileHeaders, err := parseHeadersFile(string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("invalid headers in %s: %w", path, err)
+		}
+		for k, v := range fileHeaders {
+			headers[k] = v
+		}
+	}
+
+	for k, v := range flagOrDefault(cmd, b.prefix("headers"), nil, cobrautil.MustGetStringToString) {
+		headers[k] = v
+	}
+	return headers, nil
+}
+
+// parseHeadersFile parses headers from "key=value" lines.
+//
+// Surrounding whitespace is trimmed; blank lines and lines starting with "#"
+// are ignored.
+func parseHeadersFile(contents string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for i, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected key=value", i+1)
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// parseHeaders parses headers in the format of OTEL_EXPORTER_OTLP_HEADERS:
+// a comma-separated list of "key=value" pairs with URL-encoded values.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+
+		value, err := url.QueryUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for header %q: %w", key, err)
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
+
+// insecureFromFlags returns whether the collector should be reached in
+// plaintext.
+//
+// Unless "$PREFIX-insecure" is set explicitly, WithInsecureLocalhost infers
+// plaintext for endpoints that are all local; see isLocalEndpoint.
+func (b *Builder) insecureFromFlags(cmd *cobra.Command, endpoints string) bool {
+	insecure := flagOrDefault(cmd, b.prefix("insecure"), b.defaultInsecure, cobrautil.MustGetBool)
+	if insecure || !b.insecureLocalhost || endpoints == "" || cmd.Flags().Changed(b.prefix("insecure")) {
+		return insecure
+	}
+	for _, flag := range []string{"tls-insecure-skip-verify", "tls-server-name"} {
+		if cmd.Flags().Changed(b.prefix(flag)) {
+			return insecure
+		}
+	}
+
+	for _, endpoint := range splitEndpoints(endpoints) {
+		if !isLocalEndpoint(endpoint) {
+			return insecure
+		}
+	}
+	b.logger.V(b.preRunLevel).Info("connecting to local opentelemetry collector in plaintext", "endpoint", redactEndpoints(endpoints))
+	return true
+}
+
+// isLocalEndpoint returns whether a normalized endpoint is a unix socket or
+// its host is "localhost", a subdomain of "localhost" or a loopback IP
+// address.
+func isLocalEndpoint(endpoint string) bool {
+	if grpcTargetScheme(endpoint) == "unix" {
+		return true
+	}
+
+	host, _ := splitEndpointPath(endpoint)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// tlsConfigFromFlags returns the TLS configuration used to reach the
+// collector or nil if the defaults should be used.
+//
+// Configuring TLS for a collector reached in plaintext is an error, as the
+// OTLP exporters would otherwise silently pick one over the other.
+func (b *Builder) tlsConfigFromFlags(cmd *cobra.Command, insecure bool) (*tls.Config, error) {
+	skipVerify := flagOrDefault(cmd, b.prefix("tls-insecure-skip-verify"), false, cobrautil.MustGetBool)
+	serverName := flagOrDefault(cmd, b.prefix("tls-server-name"), "", cobrautil.MustGetString)
+
+	if !skipVerify && serverName == "" {
+		return nil, nil
+	}
+
+	if insecure {
+		flag := "tls-server-name"
+		if skipVerify {
+			flag = "tls-insecure-skip-verify"
+		}
+		return nil, fmt.Errorf(
+			"failed to configure opentelemetry: --%s and --%s are mutually exclusive",
+			b.prefix("insecure"),
+			b.prefix(flag),
 		)
-		return nil
 	}
+
+	if skipVerify {
+		b.logger.Info("WARNING: OpenTelemetry collector TLS certificate verification is disabled; do not use this in production")
+	}
+
+	return &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: skipVerify, //nolint:gosec // explicitly requested by the user
+	}, nil
 }
 
-func initOtelTracer(exporter trace.SpanExporter, serviceName string, propagators []string, sampleRatio float64) error {
-	res, err := resource.New(
-		context.Background(),
-		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
-		resource.WithFromEnv(),
-		resource.WithTelemetrySDK(),
+// caCertificateEnvVars are the environment variables configuring the CA
+// certificates used by the OTLP exporters to verify the collector.
+var caCertificateEnvVars = []string{"OTEL_EXPORTER_OTLP_CERTIFICATE", "OTEL_EXPORTER_OTLP_TRACES_CERTIFICATE"}
+
+// requireCA returns an error when "$PREFIX-require-ca" is set and the
+// collector is reached over TLS without any CA certificates configured, such
+// that a collector using a private CA fails on startup rather than on every
+// export.
+//
+// Whether the system trust store would verify the collector cannot be known
+// before connecting, hence the check is opt-in. It only verifies that CA
+// certificates are configured with caCertificateEnvVars, or that
+// verification is disabled with "$PREFIX-tls-insecure-skip-verify", not that
+// they match the collector.
+func (b *Builder) requireCA(cmd *cobra.Command, provider string, insecure bool, tlsConfig *tls.Config) error {
+	if provider == "none" || insecure || !flagOrDefault(cmd, b.prefix("require-ca"), false, cobrautil.MustGetBool) {
+		return nil
+	}
+	if tlsConfig != nil && (tlsConfig.InsecureSkipVerify || tlsConfig.RootCAs != nil) {
+		return nil
+	}
+	for _, envVar := range caCertificateEnvVars {
+		if os.Getenv(envVar) != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf(
+		"--%s requires CA certificates to verify the opentelemetry collector over TLS: set %s, or --%s for a plaintext collector",
+		b.prefix("require-ca"), strings.Join(caCertificateEnvVars, " or "), b.prefix("insecure"),
 	)
+}
+
+// tracerConfig holds the resolved values used to install a tracer provider.
+type tracerConfig struct {
+	serviceName    string
+	serviceNameSet bool
+	resourceAttrs  []attribute.KeyValue
+	propagators    []string
+	sampler        trace.Sampler
+	spanLimits     trace.SpanLimits
+
+	// processor is either "batch" or "simple".
+	processor string
+
+	// blockOnFull makes the batch processor apply back-pressure to the
+	// application when its queue is full.
+	//
+	// By default, spans are dropped when the queue is full so that a slow or
+	// unreachable collector never slows down the application, at the cost of
+	// losing trace data. Blocking preserves every span but can stall any
+	// code path that ends a span until the queue drains.
+	blockOnFull bool
+
+	// maxExportBatchSize is the maximum number of spans exported at once by
+	// the batch processor, or 0 for the default.
+	maxExportBatchSize int
+
+	// exportErrorsOnly and exportMinDuration filter the spans that are
+	// exported; see filterSpanProcessor.
+	exportErrorsOnly  bool
+	exportMinDuration time.Duration
+
+	// buildInfo adds the attributes from the binary's build info to the
+	// resource.
+	buildInfo bool
+
+	// processStartTime adds the start time of the process to the resource.
+	processStartTime bool
+
+	// schemaURL overrides the schema URL of the resource.
+	schemaURL string
+
+	// exportStats is shared by the exporters of the tracer provider.
+	exportStats *exportStats
+
+	// shutdownTimeout and shutdownDropOnTimeout bound shutting down the
+	// tracer provider; see Shutdown.
+	shutdownTimeout       time.Duration
+	shutdownDropOnTimeout bool
+}
+
+func (b *Builder) initOtelTracer(ctx context.Context, exporters []trace.SpanExporter, cfg tracerConfig) error {
+	res, err := b.newResource(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	otel.SetTracerProvider(trace.NewTracerProvider(
-		trace.WithSampler(trace.ParentBased(trace.TraceIDRatioBased(sampleRatio))),
-		trace.WithBatcher(exporter),
+	var batchOpts []trace.BatchSpanProcessorOption
+	if cfg.blockOnFull {
+		batchOpts = append(batchOpts, trace.WithBlocking())
+	}
+	if cfg.maxExportBatchSize > 0 {
+		batchOpts = append(batchOpts, trace.WithMaxExportBatchSize(cfg.maxExportBatchSize))
+	}
+
+	tpOpts := []trace.TracerProviderOption{
+		trace.WithSampler(cfg.sampler),
 		trace.WithResource(res),
-	))
-	setTracePropagators(propagators)
+		trace.WithRawSpanLimits(cfg.spanLimits),
+	}
+	// Span processors run in registration order: the processors provided to
+	// WithSpanProcessors are registered after the built-in ones unless
+	// WithSpanProcessorOrder says otherwise, and the exporting processors are
+	// always registered last.
+	var builtin []trace.SpanProcessor
+	if len(b.spanAttrs) > 0 {
+		builtin = append(builtin, newConstantAttributesSpanProcessor(b.spanAttrs))
+	}
+	processors := append(builtin, b.spanProcessors...)
+	if b.spanProcessorOrder == SpanProcessorsBeforeBuiltin {
+		processors = append(append([]trace.SpanProcessor{}, b.spanProcessors...), builtin...)
+	}
+	for _, processor := range processors {
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(processor))
+	}
+
+	// Every exporter has its own processor, such that an exporter that is
+	// slow or failing does not hold back the others.
+	for _, exporter := range exporters {
+		var processor trace.SpanProcessor
+		if cfg.processor == "simple" {
+			processor = trace.NewSimpleSpanProcessor(exporter)
+		} else {
+			processor = trace.NewBatchSpanProcessor(exporter, batchOpts...)
+		}
+		if cfg.exportErrorsOnly || cfg.exportMinDuration > 0 {
+			processor = newFilterSpanProcessor(processor, cfg.exportErrorsOnly, cfg.exportMinDuration)
+		}
+		if b.keepSlowSpans > 0 {
+			processor = keepSlowSpanProcessor{SpanProcessor: processor, threshold: b.keepSlowSpans}
+		}
+		tpOpts = append(tpOpts, trace.WithSpanProcessor(processor))
+	}
+
+	tracerProvider := trace.NewTracerProvider(tpOpts...)
+	shutdown := &shutdownState{timeout: cfg.shutdownTimeout, dropOnTimeout: cfg.shutdownDropOnTimeout}
+	previous, previousShutdown := b.replaceTracerProvider(tracerProvider, shutdown, res, cfg.exportStats)
 
+	if existing := otel.GetTracerProvider(); isExternalTracerProvider(existing) {
+		if b.respectExistingGlobal {
+			b.logger.V(b.preRunLevel).Info("keeping existing global tracer provider", "existing", fmt.Sprintf("%T", existing))
+		} else {
+			b.logger.V(b.preRunLevel).Info("WARNING: overriding existing global tracer provider", "existing", fmt.Sprintf("%T", existing))
+			setGlobalTracerProvider(tracerProvider)
+		}
+	} else {
+		setGlobalTracerProvider(tracerProvider)
+	}
+	if !b.noPropagate {
+		b.setTracePropagators(cfg.propagators)
+	}
+
+	b.shutdownReplaced(ctx, previous, previousShutdown)
 	return nil
 }
 
+// replaceTracerProvider makes tracerProvider, which may be nil, the
+// configured tracer provider and returns the one it replaces.
+//
+// Only the current tracer provider is watched: the watcher of the replaced
+// tracer provider is stopped, such that reconfiguring does not accumulate
+// goroutines.
+func (b *Builder) replaceTracerProvider(tracerProvider *trace.TracerProvider, shutdown *shutdownState, res *resource.Resource, stats *exportStats) (*trace.TracerProvider, *shutdownState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	previous, previousShutdown := b.tracerProvider, b.shutdown
+	b.tracerProvider, b.shutdown, b.resource, b.exportStats = tracerProvider, shutdown, res, stats
+
+	if b.stopShutdownWatch != nil {
+		close(b.stopShutdownWatch)
+		b.stopShutdownWatch = nil
+	}
+	if tracerProvider != nil && b.shutdownCtx != nil {
+		stop := make(chan struct{})
+		b.stopShutdownWatch = stop
+		go func() {
+			select {
+			case <-b.shutdownCtx.Done():
+			case <-stop:
+				return
+			}
+			if err := b.shutdownTracerProvider(context.Background(), tracerProvider, shutdown); err != nil {
+				b.logger.Error(err, "failed to shutdown opentelemetry tracer provider")
+			}
+		}()
+	}
+	return previous, previousShutdown
+}
+
+// shutdownReplaced shuts down a replaced tracer provider, if any, such that
+// its processors and exporters do not leak; its buffered spans are flushed
+// first.
+func (b *Builder) shutdownReplaced(ctx context.Context, previous *trace.TracerProvider, previousShutdown *shutdownState) {
+	if previous == nil {
+		return
+	}
+	if err := b.shutdownTracerProvider(ctx, previous, previousShutdown); err != nil {
+		b.logger.Error(err, "failed to shutdown replaced opentelemetry tracer provider")
+	}
+}
+
+// currentTracerProvider returns the configured tracer provider, or nil, and
+// its shutdown state.
+func (b *Builder) currentTracerProvider() (*trace.TracerProvider, *shutdownState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tracerProvider, b.shutdown
+}
+
+// Resource returns the resource attached to every span by the tracer
+// provider configured by RunE(), such that other signals can share it.
+//
+// Resource returns nil if no tracer provider was configured, e.g. for the
+// "none" provider.
+func (b *Builder) Resource() *resource.Resource {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.resource
+}
+
+// Tracer returns a tracer from the tracer provider configured by RunE().
+//
+// An empty instrumentation scope name defaults to the name provided to
+// WithScopeName, or else the module path of the binary. The instrumentation
+// scope version defaults to the version of the binary, which can be
+// overridden by providing oteltrace.WithInstrumentationVersion.
+// A no-op tracer is returned if no tracer provider was configured, e.g. when
+// the provider is "none".
+func (b *Builder) Tracer(name string, opts ...oteltrace.TracerOption) oteltrace.Tracer {
+	tracerProvider, _ := b.currentTracerProvider()
+	if tracerProvider == nil {
+		return oteltrace.NewNoopTracerProvider().Tracer(name, opts...)
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if name == "" {
+		name = b.scopeName
+		if name == "" && ok {
+			name = bi.Main.Path
+		}
+	}
+	if ok {
+		if version := cobrautil.VersionWithFallbacks(bi); version != "" {
+			opts = append([]oteltrace.TracerOption{oteltrace.WithInstrumentationVersion(version)}, opts...)
+		}
+	}
+	return tracerProvider.Tracer(name, opts...)
+}
+
+// Shutdown flushes any buffered spans and stops the tracer provider
+// configured by RunE().
+//
+// A command span that is still in progress is ended first.
+//
+// When "$PREFIX-shutdown-timeout" is set, spans that were not flushed once
+// it elapses are dropped and Shutdown returns an error wrapping
+// context.DeadlineExceeded, unless "$PREFIX-shutdown-drop-on-timeout" is
+// false, in which case Shutdown keeps waiting for the flush to complete.
+//
+// The tracer provider is only shutdown once: subsequent calls, including the
+// one triggered by WithShutdownOnContext, return the result of the first
+// call. Reconfiguring shuts down the replaced tracer provider, after which
+// Shutdown applies to the new one. Shutdown is a no-op if no tracer provider
+// was configured.
+func (b *Builder) Shutdown(ctx context.Context) error {
+	tracerProvider, shutdown := b.currentTracerProvider()
+	if tracerProvider == nil {
+		return nil
+	}
+	return b.shutdownTracerProvider(ctx, tracerProvider, shutdown)
+}
+
+// shutdownTracerProvider ends the command span and shuts down the tracer
+// provider unless it was already shut down, returning the result of the first
+// shutdown.
+func (b *Builder) shutdownTracerProvider(ctx context.Context, tracerProvider *trace.TracerProvider, shutdown *shutdownState) error {
+	b.endCommandSpan(nil)
+	shutdown.once.Do(func() {
+		shutdown.err = b.shutdownWithTimeout(ctx, tracerProvider, shutdown.timeout, shutdown.dropOnTimeout)
+	})
+	return shutdown.err
+}
+
+func (b *Builder) shutdownWithTimeout(ctx context.Context, tracerProvider *trace.TracerProvider, timeout time.Duration, dropOnTimeout bool) error {
+	if timeout <= 0 {
+		return tracerProvider.Shutdown(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if dropOnTimeout {
+		ctx = timeoutCtx
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- tracerProvider.Shutdown(ctx) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timeoutCtx.Done():
+	}
+
+	if dropOnTimeout {
+		b.logger.Info("WARNING: opentelemetry tracer provider did not shut down in time; dropping unflushed spans", "timeout", timeout)
+		return fmt.Errorf("failed to flush spans before shutdown: %w", timeoutCtx.Err())
+	}
+	b.logger.Info("WARNING: opentelemetry tracer provider is taking long to shut down; waiting for unflushed spans", "timeout", timeout)
+	return <-done
+}
+
 // setTextMapPropagator sets the OpenTelemetry trace propagation format.
 // Currently it supports b3, ot-trace and w3c.
 func setTracePropagators(propagators []string) {
+	otel.SetTextMapPropagator(newTracePropagator(propagators))
+}
+
+// newTracePropagator returns a propagator for the named trace propagation
+// formats.
+func newTracePropagator(propagators []string) propagation.TextMapPropagator {
 	var tmPropagators []propagation.TextMapPropagator
 
 	for _, p := range propagators {
@@ -230,7 +1344,7 @@ func setTracePropagators(propagators []string) {
 		}
 	}
 
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(tmPropagators...))
+	return propagation.NewCompositeTextMapPropagator(tmPropagators...)
 }
 
 // WithLogger configures logging of the configured OpenTelemetry environment.
@@ -238,6 +1352,464 @@ func WithLogger(logger logr.Logger) Option {
 	return func(b *Builder) { b.logger = logger }
 }
 
+// WithLoggerV configures logging of the configured OpenTelemetry environment
+// and the level used for pre-run log messages.
+//
+// This is equivalent to using both WithLogger and WithPreRunLevel.
+func WithLoggerV(logger logr.Logger, v int) Option {
+	return func(b *Builder) {
+		b.logger = logger
+		b.preRunLevel = v
+	}
+}
+
+// WithShutdownOnContext shuts down the configured tracer provider once the
+// provided context is done.
+//
+// This can be combined with calling Shutdown directly: whichever happens
+// first shuts down the tracer provider and the other becomes a no-op.
+func WithShutdownOnContext(ctx context.Context) Option {
+	return func(b *Builder) { b.shutdownCtx = ctx }
+}
+
+// WithServiceName overrides the default service name provided to New, e.g.
+// for a Builder returned by Child.
+func WithServiceName(serviceName string) Option {
+	return func(b *Builder) { b.serviceName = serviceName }
+}
+
+// WithServiceNameSanitizer normalizes the resolved service name before it is
+// added to the resource attached to every span, e.g. to lowercase it or
+// replace spaces for backends that reject them.
+//
+// The sanitizer applies to the service name from any source, including
+// OTEL_SERVICE_NAME.
+func WithServiceNameSanitizer(sanitize func(string) string) Option {
+	return func(b *Builder) { b.serviceNameSanitizer = sanitize }
+}
+
+// WithServiceNameFromEnvFallback configures environment variables that are
+// consulted in order for the service name when "$PREFIX-service-name" is not
+// set, before falling back to the default service name.
+//
+// The first non-empty variable wins, even over OTEL_SERVICE_NAME, which is
+// otherwise honored when the flag is not set; it can be included in the
+// list to define its precedence, e.g.:
+//
+//	WithServiceNameFromEnvFallback("OTEL_SERVICE_NAME", "APP_NAME")
+func WithServiceNameFromEnvFallback(envVars ...string) Option {
+	return func(b *Builder) { b.serviceNameEnvVars = envVars }
+}
+
+// WithServiceNameFromArg uses the positional argument at index as the service
+// name in RunE(), e.g. for generic tooling taking the name of the service it
+// acts on as an argument.
+//
+// "$PREFIX-service-name" and the environment variables provided to
+// WithServiceNameFromEnvFallback take precedence. The default service name is
+// used when there is no argument at index.
+func WithServiceNameFromArg(index int) Option {
+	return func(b *Builder) {
+		b.serviceNameArg = index
+		b.serviceNameFromArg = true
+	}
+}
+
+// WithServiceNameFunc computes the service name in RunE(), e.g. from
+// configuration loaded by an earlier PreRun, overriding every other source of
+// the service name unless it returns an empty string.
+//
+// The service name is resolved in the following order:
+// 1. the name returned by fn
+// 2. the "$PREFIX-service-name" flag
+// 3. the environment variables provided to WithServiceNameFromEnvFallback
+// 4. the argument selected by WithServiceNameFromArg
+// 5. the name provided to New or WithServiceName, or else the module path
+// of the binary
+func WithServiceNameFunc(fn func() string) Option {
+	return func(b *Builder) { b.serviceNameFunc = fn }
+}
+
+// WithDefaultInsecure defines the default value of the "$PREFIX-insecure"
+// flag.
+func WithDefaultInsecure(insecure bool) Option {
+	return func(b *Builder) { b.defaultInsecure = insecure }
+}
+
+// WithInsecureLocalhost connects to local collectors in plaintext unless the
+// "$PREFIX-insecure" flag or any TLS flag is set explicitly.
+//
+// An endpoint is local when its host is "localhost", a subdomain of
+// "localhost" (e.g. "collector.localhost") or a loopback IP address (e.g.
+// "127.0.0.1" or "[::1]"); with multiple endpoints, all of them must be
+// local.
+func WithInsecureLocalhost() Option {
+	return func(b *Builder) { b.insecureLocalhost = true }
+}
+
+// WithArgsRedactor redacts the arguments recorded on the span emitted by
+// "$PREFIX-trace-command", e.g. to remove secrets.
+//
+// The redactor is provided the positional arguments of the command and
+// returns the arguments to record.
+func WithArgsRedactor(redactor func(args []string) []string) Option {
+	return func(b *Builder) { b.argsRedactor = redactor }
+}
+
+// WithScopeName sets the instrumentation scope name of the tracers returned
+// by Tracer when called with an empty name, e.g. the module path of the
+// program, instead of the module path of the binary.
+func WithScopeName(name string) Option {
+	return func(b *Builder) { b.scopeName = name }
+}
+
+// WithCommandSpanOptions adds options used to start the span emitted by
+// "$PREFIX-trace-command", e.g. to set its kind or add attributes.
+//
+// They are applied after the defaults: the span kind defaults to
+// SpanKindInternal, and the "cli.command" and "cli.args" attributes are
+// always recorded unless overridden.
+func WithCommandSpanOptions(opts ...oteltrace.SpanStartOption) Option {
+	return func(b *Builder) { b.commandSpanOpts = append(b.commandSpanOpts, opts...) }
+}
+
+// WithExportOnPanic makes the run funcs wrapped with WrapRunE flush the spans
+// recorded so far when they panic, before the panic is propagated, such that
+// the trace of the failing command is not lost with the batch queue.
+//
+// The span emitted by "$PREFIX-trace-command" records the panic as an error.
+// Flushing is bounded by "$PREFIX-shutdown-timeout". It is disabled by
+// default.
+func WithExportOnPanic() Option {
+	return func(b *Builder) { b.exportOnPanic = true }
+}
+
+// WithExportErrorBackpressure registers a callback invoked once an exporter
+// has failed to export spans threshold consecutive times, e.g. to raise an
+// alert when the collector is down instead of silently dropping spans.
+//
+// The callback is invoked with the number of consecutive failures and the
+// last error, once per outage: the count is reset by the next successful
+// export. With multiple providers, failures are counted per provider.
+func WithExportErrorBackpressure(threshold int, callback func(failures int, err error)) Option {
+	return func(b *Builder) {
+		if threshold < 1 {
+			threshold = 1
+		}
+		b.backpressureThreshold = threshold
+		b.backpressureCallback = callback
+	}
+}
+
+// WithBatchExportErrorHandler registers a handler invoked with every error
+// returned by an exporter to the span processor, e.g. to count failed exports
+// separately from the other errors reported to the global OpenTelemetry
+// error handler.
+//
+// The errors are still reported to the global error handler by the span
+// processor.
+func WithBatchExportErrorHandler(handler func(err error)) Option {
+	return func(b *Builder) { b.exportErrorHandler = handler }
+}
+
+// WithDroppedSpanCallback registers a callback invoked with the number of
+// spans dropped because the queue of the batch span processor was full.
+//
+// Drops are reported when the processor logs them before its next export,
+// which requires installing a logger for OpenTelemetry that intercepts the
+// processor's debug messages. Counts therefore depend on the internals of the
+// OpenTelemetry SDK: they are approximate and may be delayed until the next
+// export.
+func WithDroppedSpanCallback(callback func(count int)) Option {
+	return func(b *Builder) { b.droppedSpanCallback = callback }
+}
+
+// WithConstantSpanAttributes sets the provided attributes on every span.
+//
+// Unlike resource attributes, e.g. those added with WithEnvAttributes, these
+// are span attributes, which some backends index differently. Attributes
+// provided when starting a span or set on it later take precedence.
+func WithConstantSpanAttributes(attrs ...attribute.KeyValue) Option {
+	return func(b *Builder) { b.spanAttrs = append(b.spanAttrs, attrs...) }
+}
+
+// WithSpanProcessors registers additional span processors, e.g. to scrub
+// sensitive attributes, in the order provided.
+//
+// They run after the built-in processors, such as the one setting the
+// attributes provided to WithConstantSpanAttributes, unless
+// WithSpanProcessorOrder says otherwise. They always run before spans are
+// handed to the exporters.
+func WithSpanProcessors(processors ...trace.SpanProcessor) Option {
+	return func(b *Builder) { b.spanProcessors = append(b.spanProcessors, processors...) }
+}
+
+// SpanProcessorOrder is the order in which the processors provided to
+// WithSpanProcessors run relative to the built-in processors.
+type SpanProcessorOrder int
+
+const (
+	// SpanProcessorsAfterBuiltin runs the provided processors after the
+	// built-in processors, such that they observe the attributes those set.
+	SpanProcessorsAfterBuiltin SpanProcessorOrder = iota
+
+	// SpanProcessorsBeforeBuiltin runs the provided processors before the
+	// built-in processors.
+	SpanProcessorsBeforeBuiltin
+)
+
+// WithSpanProcessorOrder controls whether the processors provided to
+// WithSpanProcessors run before or after the built-in processors. It
+// defaults to SpanProcessorsAfterBuiltin.
+func WithSpanProcessorOrder(order SpanProcessorOrder) Option {
+	return func(b *Builder) { b.spanProcessorOrder = order }
+}
+
+// WithKeepSlowSpans exports root spans lasting at least threshold even when
+// the sampler dropped them, approximating tail sampling of slow requests
+// without a collector.
+//
+// This is only an approximation: the sampling decision is made when a span
+// starts, so only the slow root span itself is exported, without its child
+// spans or the spans of downstream services, which were not sampled. Root
+// spans dropped by the sampler are also recorded until they end, which costs
+// some memory and CPU. Spans matching "$PREFIX-ignore-attributes" and spans
+// dropped by the "always_off" samplers are never exported. It is disabled by
+// default.
+func WithKeepSlowSpans(threshold time.Duration) Option {
+	return func(b *Builder) { b.keepSlowSpans = threshold }
+}
+
+// WithExtractPropagators defines the trace propagation formats used to
+// extract trace context from inbound requests, instead of those provided by
+// "$PREFIX-trace-propagator".
+//
+// Formats are named like the values of "$PREFIX-trace-propagator", e.g. to
+// accept "b3" from legacy clients while only emitting "w3c".
+func WithExtractPropagators(propagators ...string) Option {
+	return func(b *Builder) { b.extractPropagators = propagators }
+}
+
+// WithInjectPropagators defines the trace propagation formats used to inject
+// trace context into outbound requests, instead of those provided by
+// "$PREFIX-trace-propagator".
+//
+// Formats are named like the values of "$PREFIX-trace-propagator".
+func WithInjectPropagators(propagators ...string) Option {
+	return func(b *Builder) { b.injectPropagators = propagators }
+}
+
+// WithConfiguredSpan emits a span named "otel.configured" once a tracer
+// provider is configured, recording the provider, endpoint, processor,
+// propagators and sampler as attributes, e.g. as an audit trail or to check
+// that spans reach the collector.
+//
+// The span is always sampled, but may be dropped by the filters of
+// "$PREFIX-export-errors-only" and "$PREFIX-export-min-duration". The
+// endpoint is recorded without any user info, query or fragment. It is
+// disabled by default.
+func WithConfiguredSpan() Option {
+	return func(b *Builder) { b.configuredSpan = true }
+}
+
+// WithTraceState adds a member to the W3C tracestate of every root span, e.g.
+// a vendor entry used by downstream systems for routing decisions. Child
+// spans inherit the tracestate of their parent.
+//
+// The key and value must follow the W3C Trace Context grammar; invalid
+// members are returned as an error by RunE().
+func WithTraceState(key, value string) Option {
+	return func(b *Builder) {
+		b.traceStateKey = key
+		b.traceStateValue = value
+	}
+}
+
+// WithLenientExtraction repairs common malformations of inbound "traceparent"
+// headers, such as an invalid version or missing trace flags, before trace
+// context is extracted, instead of dropping the trace context as required by
+// the W3C Trace Context specification.
+//
+// Repairs are logged at the pre-run level. It is disabled by default.
+func WithLenientExtraction() Option {
+	return func(b *Builder) { b.lenientExtraction = true }
+}
+
+// WithResourceOptions adds resource options, such as resource.WithContainer
+// or resource.WithOSType, used to build the resource attached to every span.
+//
+// The attributes they produce are applied last, overriding any other
+// attribute with the same key.
+func WithResourceOptions(opts ...resource.Option) Option {
+	return func(b *Builder) { b.resourceOpts = append(b.resourceOpts, opts...) }
+}
+
+// WithResourceTimeout bounds the time spent running the detectors provided to
+// WithResourceDetectors and the options provided to WithResourceOptions, such
+// that a detector hanging on a slow metadata endpoint does not stall startup.
+//
+// Detection that does not complete in time is logged and skipped: the
+// resource is built from the remaining attributes, including the service
+// name. It defaults to 5s; a non-positive timeout disables it.
+func WithResourceTimeout(timeout time.Duration) Option {
+	return func(b *Builder) { b.resourceTimeout = timeout }
+}
+
+// WithResourceJSON adds resource attributes parsed from a JSON object, e.g.
+// {"deployment.environment":"prod"}, such as one generated by deploy tooling.
+//
+// Numbers and booleans are coerced to strings; other values, and data that
+// is not a JSON object, cause RunE() to return an error.
+func WithResourceJSON(data []byte) Option {
+	return func(b *Builder) { b.resourceJSON = data }
+}
+
+// WithRespectExistingGlobal keeps the global tracer provider if one was
+// already installed, e.g. by another library, instead of replacing it. Tracer
+// providers installed by any Builder, such as one created with Child, are
+// always replaced.
+//
+// The tracer provider configured by RunE() is still available with Tracer.
+// By default, an existing global tracer provider is replaced and a warning
+// is logged at the pre-run level.
+func WithRespectExistingGlobal() Option {
+	return func(b *Builder) { b.respectExistingGlobal = true }
+}
+
+// WithInstallNoopOnNone installs a no-op global tracer provider and an empty
+// global text map propagator for the "none" provider, such that code
+// inspecting the globals finds known instances rather than the OpenTelemetry
+// defaults. By default, the globals are left untouched for the "none"
+// provider.
+//
+// WithRespectExistingGlobal and WithoutPropagator are honored.
+func WithInstallNoopOnNone() Option {
+	return func(b *Builder) { b.installNoopOnNone = true }
+}
+
+// WithStartupRetryJitter overrides how the delay between startup retries,
+// configured by "$PREFIX-startup-retry-delay", is randomized: jitter returns
+// the delay to wait for the configured delay.
+//
+// It defaults to full jitter, i.e. a random delay between 0 and the
+// configured delay, which spreads out the retries of replicas starting at
+// once. A jitter returning its argument disables randomization.
+func WithStartupRetryJitter(jitter func(delay time.Duration) time.Duration) Option {
+	return func(b *Builder) { b.startupRetryJitter = jitter }
+}
+
+// WithSetupTimeout bounds the time RunE spends constructing the exporter
+// and tracer provider, e.g. a custom exporter dialing an unreachable
+// collector. It only bounds setup: the built-in OTLP exporters connect
+// lazily, so an unreachable collector surfaces as export errors instead.
+//
+// When the timeout is exceeded, RunE returns an error wrapping
+// context.DeadlineExceeded. By default, setup is not bounded.
+func WithSetupTimeout(timeout time.Duration) Option {
+	return func(b *Builder) { b.setupTimeout = timeout }
+}
+
+// WithExporterWrapper wraps the exporter created for the configured provider,
+// e.g. to redact spans before they are exported.
+//
+// The wrapper is not called when the provider is "none".
+func WithExporterWrapper(wrapper func(trace.SpanExporter) trace.SpanExporter) Option {
+	return func(b *Builder) { b.wrapper = wrapper }
+}
+
+// WithEnvAttributes adds resource attributes read from environment variables,
+// e.g. those populated by the Kubernetes downward API.
+//
+// The provided map is keyed by attribute key with the name of the environment
+// variable as the value. Variables that are unset or empty are skipped.
+func WithEnvAttributes(attrs map[string]string) Option {
+	return func(b *Builder) { b.envAttrs = attrs }
+}
+
+// WithResourceDetectors adds the attributes found by the provided detectors,
+// e.g. cloud-specific detectors, to the resource attached to every span.
+//
+// Errors returned by the detectors are logged and otherwise ignored.
+func WithResourceDetectors(detectors ...resource.Detector) Option {
+	return func(b *Builder) { b.detectors = append(b.detectors, detectors...) }
+}
+
+// WithoutPropagator leaves the global text map propagator untouched while
+// still installing the tracer provider.
+//
+// This is useful for programs that have no requests to propagate context over
+// or that configure propagation elsewhere.
+func WithoutPropagator() Option {
+	return func(b *Builder) { b.noPropagate = true }
+}
+
+// WithDefaultEndpointFile defines the default value of the
+// "$PREFIX-endpoint-file" flag, e.g. a file projected by Kubernetes.
+//
+// Unlike an explicitly provided path, the default file is silently skipped if
+// it does not exist.
+func WithDefaultEndpointFile(path string) Option {
+	return func(b *Builder) { b.defaultEndpointFile = path }
+}
+
+// WithEnabledFlag makes RunE() a no-op unless the named bool flag is true.
+//
+// The flag must be registered on the command separately; RunE() returns an
+// error if it is missing.
+func WithEnabledFlag(name string) Option {
+	return func(b *Builder) { b.enabledFlag = name }
+}
+
+// WithUserAgent sets the user agent used by the OTLP exporters, e.g. to
+// attribute traffic to an application on the collector.
+//
+// For "otlpgrpc", the gRPC library appends its own version to the value.
+// Defaults to the user agent of the OpenTelemetry exporter.
+func WithUserAgent(userAgent string) Option {
+	return func(b *Builder) { b.userAgent = userAgent }
+}
+
+// WithClock overrides the source of the current time used by time-dependent
+// samplers, such as the one enforcing "$PREFIX-max-spans-per-second".
+//
+// This is intended for deterministic tests. Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(b *Builder) { b.now = now }
+}
+
+// WithHeadersFromEnv reads headers sent with every export request from the
+// named environment variable, e.g. one populated by secret injection.
+//
+// The variable uses the same format as OTEL_EXPORTER_OTLP_HEADERS:
+// "key1=value1,key2=value2". Headers provided by the "$PREFIX-headers" flag
+// take precedence.
+func WithHeadersFromEnv(envVar string) Option {
+	return func(b *Builder) { b.headersEnvVar = envVar }
+}
+
+// WithDisableLegacyFlags prevents the hidden, deprecated "otel-jaeger-*"
+// flags from being registered.
+func WithDisableLegacyFlags() Option {
+	return func(b *Builder) { b.disableLegacyFlags = true }
+}
+
+// WithSampler defines the sampler used for traces.
+//
+// It takes precedence over every sampling flag except
+// "$PREFIX-ignore-attributes".
+func WithSampler(sampler trace.Sampler) Option {
+	return func(b *Builder) { b.sampler = sampler }
+}
+
+// WithDynamicSampler defines a function making the sampling decision for
+// every span, e.g. to change sampling at runtime based on feature flags.
+//
+// It is equivalent to providing WithSampler with a sampler calling the
+// function.
+func WithDynamicSampler(shouldSample func(trace.SamplingParameters) trace.SamplingResult) Option {
+	return WithSampler(dynamicSampler(shouldSample))
+}
+
 // WithFlagPrefix defines prefix used with the generated flags.
 //
 // Defaults to "log".
@@ -0,0 +1,1474 @@
+package cobraotel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/jzelinskie/stringz"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+func newTestCommand(t *testing.T, b *Builder, args ...string) *cobra.Command {
+	t.Helper()
+	t.Cleanup(ResetGlobalsForTest)
+
+	cmd := &cobra.Command{Use: "test"}
+	b.RegisterFlags(cmd.Flags())
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatalf("failed to parse flags: %s", err)
+	}
+	return cmd
+}
+
+var inMemoryProviders atomic.Int64
+
+// registerInMemoryProvider registers a provider exporting to the returned
+// in-memory exporter under a unique name.
+func registerInMemoryProvider(t *testing.T) (string, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	name := fmt.Sprintf("fake-in-memory-%d", inMemoryProviders.Add(1))
+	exporter := tracetest.NewInMemoryExporter()
+	registerTestProvider(t, name, func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		return exporter, nil
+	})
+	return name, exporter
+}
+
+// registerTestProvider registers the factory under name, which is
+// unregistered along with the globals installed by the test once it
+// completes.
+func registerTestProvider(t *testing.T, name string, factory ExporterFactory) {
+	t.Helper()
+
+	if err := RegisterProvider(name, factory); err != nil {
+		t.Fatalf("failed to register provider: %s", err)
+	}
+	t.Cleanup(func() {
+		providersMu.Lock()
+		defer providersMu.Unlock()
+		delete(providers, strings.ToLower(strings.TrimSpace(name)))
+	})
+	t.Cleanup(ResetGlobalsForTest)
+}
+
+func TestRegisterProvider(t *testing.T) {
+	var got ExporterOptions
+	registerTestProvider(t, "fake", func(ctx context.Context, opts ExporterOptions) (trace.SpanExporter, error) {
+		got = opts
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=fake", "--otel-endpoint=collector:4317", "--otel-insecure")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	if got.Endpoint != "collector:4317" || !got.Insecure {
+		t.Fatalf("factory received unexpected options: %+v", got)
+	}
+}
+
+func TestRegisterProviderMixedCase(t *testing.T) {
+	var called bool
+	registerTestProvider(t, " MyCorp ", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		called = true
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=MyCorp")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if !called {
+		t.Fatal("expected the provider registered as \"MyCorp\" to be selected")
+	}
+}
+
+func TestRegisterProviderReservedName(t *testing.T) {
+	names := []string{"", "  "}
+	for _, name := range builtinProviders {
+		names = append(names, name, strings.ToUpper(name), " "+name+" ")
+	}
+	for _, name := range names {
+		if err := RegisterProvider(name, func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+			return nil, nil
+		}); err == nil {
+			t.Fatalf("expected registering %q to fail", name)
+		}
+	}
+}
+
+func TestInferProvider(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint string
+		expected string
+	}{
+		{"grpc://collector:9000", "otlpgrpc"},
+		{"GRPC://collector", "otlpgrpc"},
+		{"unix:///var/run/otel.sock", "otlpgrpc"},
+		{"dns:///collector:4317", "otlpgrpc"},
+		{"http://collector:4317", "otlphttp"},
+		{"https://collector", "otlphttp"},
+		{"collector:4317", "otlpgrpc"},
+		{"collector:4318", "otlphttp"},
+		{"collector:9000/v1/traces", "otlphttp"},
+	} {
+		got, err := inferProvider(tt.endpoint)
+		if err != nil {
+			t.Fatalf("unexpected error inferring provider for %q: %s", tt.endpoint, err)
+		}
+		if got != tt.expected {
+			t.Fatalf("expected %q to infer %q, got %q", tt.endpoint, tt.expected, got)
+		}
+	}
+
+	for _, endpoint := range []string{"collector", "collector:9000", "ftp://collector:4317"} {
+		if got, err := inferProvider(endpoint); err == nil {
+			t.Fatalf("expected an error inferring provider for %q, got %q", endpoint, got)
+		}
+	}
+}
+
+func TestAutoProvider(t *testing.T) {
+	for _, tt := range []struct {
+		provider string
+		endpoint string
+		expected string
+	}{
+		{"otlpgrpc", "", "otlpgrpc"},
+		{"auto", "https://a:4318,b:4318", "otlphttp"},
+		{"auto+auto", "grpc://a:4317+https://b", "otlpgrpc+otlphttp"},
+		{"otlphttp+auto", "a:4318+b:4317", "otlphttp+otlpgrpc"},
+	} {
+		got, err := resolveProviders(tt.provider, tt.endpoint)
+		if err != nil {
+			t.Fatalf("unexpected error resolving %q for %q: %s", tt.provider, tt.endpoint, err)
+		}
+		if got != tt.expected {
+			t.Fatalf("expected %q for %q to resolve to %q, got %q", tt.provider, tt.endpoint, tt.expected, got)
+		}
+	}
+
+	for _, endpoint := range []string{"", "a:4317,b:4318"} {
+		if _, err := resolveProviders("auto", endpoint); err == nil {
+			t.Fatalf("expected an error resolving the auto provider for %q", endpoint)
+		}
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	if got, err := resolveProviders("auto", ""); err != nil || got != "otlphttp" {
+		t.Fatalf("expected the auto provider to be inferred from the environment, got %q (%v)", got, err)
+	}
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=auto", "--otel-endpoint=grpc://localhost:4317")
+	cfg, err := b.configFromFlags(cmd, nil)
+	if err != nil {
+		t.Fatalf("failed to read flags: %s", err)
+	}
+	if cfg.Provider != "otlpgrpc" {
+		t.Fatalf("expected provider %q, got %q", "otlpgrpc", cfg.Provider)
+	}
+}
+
+func TestOTLPProvider(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		protocol       string
+		tracesProtocol string
+		expected       string
+	}{
+		{"default", "", "", "otlpgrpc"},
+		{"grpc", "grpc", "", "otlpgrpc"},
+		{"http", "http/protobuf", "", "otlphttp"},
+		{"traces", "grpc", "HTTP/PROTOBUF", "otlphttp"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", tt.protocol)
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", tt.tracesProtocol)
+
+			b := New("test")
+			cmd := newTestCommand(t, b, "--otel-provider=otlp", "--otel-endpoint=collector:4317")
+			cfg, err := b.configFromFlags(cmd, nil)
+			if err != nil {
+				t.Fatalf("failed to read flags: %s", err)
+			}
+			if cfg.Provider != tt.expected {
+				t.Fatalf("expected provider %q, got %q", tt.expected, cfg.Provider)
+			}
+		})
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "")
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=otlp")
+	if err := b.RunE()(cmd, nil); err == nil || !strings.Contains(err.Error(), `"http/json"`) {
+		t.Fatalf("expected an unsupported protocol error, got %v", err)
+	}
+}
+
+func TestTLSServerName(t *testing.T) {
+	var got ExporterOptions
+	registerTestProvider(t, "fake-tls", func(ctx context.Context, opts ExporterOptions) (trace.SpanExporter, error) {
+		got = opts
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=fake-tls", "--otel-tls-server-name=collector.internal")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	if got.TLSConfig == nil || got.TLSConfig.ServerName != "collector.internal" {
+		t.Fatalf("expected server name to be threaded into TLS config, got: %+v", got.TLSConfig)
+	}
+}
+
+func TestInsecureTLSConflict(t *testing.T) {
+	for _, provider := range []string{"otlpgrpc", "otlphttp"} {
+		for _, flag := range []string{"--otel-tls-server-name=collector.internal", "--otel-tls-insecure-skip-verify"} {
+			t.Run(provider+" "+flag, func(t *testing.T) {
+				b := New("test")
+				cmd := newTestCommand(t, b, "--otel-provider="+provider, "--otel-endpoint=collector:4317", "--otel-insecure", flag)
+				err := b.RunE()(cmd, nil)
+				if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+					t.Fatalf("expected a mutually exclusive error, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestOTLPTracesPath(t *testing.T) {
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case paths <- r.URL.Path:
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	b := New("test")
+	cmd := newTestCommand(t, b,
+		"--otel-provider=otlphttp",
+		"--otel-endpoint="+strings.TrimPrefix(srv.URL, "http://"),
+		"--otel-insecure",
+		"--otel-otlp-traces-path=/custom/traces",
+		"--otel-processor=simple",
+		"--otel-sample-ratio=1",
+	)
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	defer func() { _ = b.Shutdown(context.Background()) }()
+
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	if got := <-paths; got != "/custom/traces" {
+		t.Fatalf("expected export to /custom/traces, got %s", got)
+	}
+}
+
+func TestEndpointNormalization(t *testing.T) {
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case paths <- r.URL.Path:
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	b := New("test")
+	cmd := newTestCommand(t, b,
+		"--otel-provider=otlphttp",
+		"--otel-endpoint="+srv.URL+"//custom//traces/?token=abc#section",
+		"--otel-insecure",
+		"--otel-processor=simple",
+		"--otel-sample-ratio=1",
+	)
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	defer func() { _ = b.Shutdown(context.Background()) }()
+
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	if got := <-paths; got != "/custom/traces" {
+		t.Fatalf("expected export to /custom/traces, got %s", got)
+	}
+}
+
+// acceptCountingListener counts the connections it accepts.
+type acceptCountingListener struct {
+	net.Listener
+	accepted chan struct{}
+}
+
+func (l *acceptCountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		select {
+		case l.accepted <- struct{}{}:
+		default:
+		}
+	}
+	return conn, err
+}
+
+func TestGRPCTargetEndpoints(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		network  string
+		endpoint func(addr string) string
+	}{
+		{"unix", "unix", func(addr string) string { return "unix://" + addr }},
+		{"dns", "tcp", func(addr string) string {
+			_, port, _ := net.SplitHostPort(addr)
+			return "dns:///localhost:" + port
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := "127.0.0.1:0"
+			if tt.network == "unix" {
+				addr = filepath.Join(t.TempDir(), "otel.sock")
+			}
+			lis, err := net.Listen(tt.network, addr)
+			if err != nil {
+				t.Fatalf("failed to listen: %s", err)
+			}
+			counting := &acceptCountingListener{Listener: lis, accepted: make(chan struct{}, 1)}
+
+			// The server implements no services: exports fail, but the
+			// connection shows that the endpoint was dialed.
+			srv := grpc.NewServer()
+			go func() { _ = srv.Serve(counting) }()
+			defer srv.Stop()
+
+			b := New("test")
+			cmd := newTestCommand(t, b,
+				"--otel-provider=otlpgrpc",
+				"--otel-endpoint="+tt.endpoint(lis.Addr().String()),
+				"--otel-insecure",
+				"--otel-processor=simple",
+				"--otel-sample-ratio=1",
+			)
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+			defer func() { _ = b.Shutdown(context.Background()) }()
+
+			_, span := b.Tracer("test").Start(context.Background(), "span")
+			span.End()
+
+			select {
+			case <-counting.accepted:
+			case <-time.After(5 * time.Second):
+				t.Fatal("expected the exporter to connect to the endpoint")
+			}
+		})
+	}
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=otlphttp", "--otel-endpoint=unix:///var/run/otel.sock")
+	if err := b.RunE()(cmd, nil); err == nil {
+		t.Fatal("expected unix endpoints to be rejected by the otlphttp provider")
+	}
+}
+
+func TestWithSetupTimeout(t *testing.T) {
+	registerTestProvider(t, "fake-blocking", func(ctx context.Context, opts ExporterOptions) (trace.SpanExporter, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	b := New("test", WithSetupTimeout(10*time.Millisecond))
+	cmd := newTestCommand(t, b, "--otel-provider=fake-blocking")
+	err := b.RunE()(cmd, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	deadlines := make(chan bool, 1)
+	registerTestProvider(t, "fake-connect", func(ctx context.Context, opts ExporterOptions) (trace.SpanExporter, error) {
+		_, ok := ctx.Deadline()
+		deadlines <- ok
+		if opts.Endpoint == "unreachable:4317" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	for _, tt := range []struct {
+		name             string
+		args             []string
+		setupTimeout     time.Duration
+		expectedDeadline bool
+		expectedErr      string
+	}{
+		{"default", nil, 0, true, ""},
+		{"disabled", []string{"--otel-connect-timeout=0"}, 0, false, ""},
+		{"exceeded", []string{"--otel-connect-timeout=10ms", "--otel-endpoint=unreachable:4317"}, 0, true, "did not connect within 10ms"},
+		{"setup timeout exceeded first", []string{"--otel-connect-timeout=0", "--otel-endpoint=unreachable:4317"}, 10 * time.Millisecond, true, "setup did not complete in time"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.setupTimeout > 0 {
+				opts = append(opts, WithSetupTimeout(tt.setupTimeout))
+			}
+			b := New("test", opts...)
+			cmd := newTestCommand(t, b, append([]string{"--otel-provider=fake-connect"}, tt.args...)...)
+
+			err := b.RunE()(cmd, nil)
+			if tt.expectedErr == "" && err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+			if tt.expectedErr != "" && (!errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), tt.expectedErr)) {
+				t.Fatalf("expected deadline exceeded error containing %q, got %v", tt.expectedErr, err)
+			}
+			if deadline := <-deadlines; deadline != tt.expectedDeadline {
+				t.Fatalf("expected exporter context deadline to be %t", tt.expectedDeadline)
+			}
+		})
+	}
+}
+
+func TestConnectTimeoutOTLPGRPC(t *testing.T) {
+	// The gRPC exporter dials without blocking, such that the connect and
+	// setup timeouts do not fail startup for an unreachable collector.
+	b := New("test", WithSetupTimeout(time.Second))
+	cmd := newTestCommand(t, b, "--otel-provider=otlpgrpc", "--otel-endpoint=127.0.0.1:1", "--otel-insecure", "--otel-connect-timeout=100ms")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %s", err)
+	}
+}
+
+func TestWithoutPropagator(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	existing := propagation.NewCompositeTextMapPropagator(propagation.Baggage{})
+	otel.SetTextMapPropagator(existing)
+
+	b := New("test", WithoutPropagator())
+	cmd := newTestCommand(t, b, "--otel-provider="+provider, "--otel-trace-propagator=b3")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	fields := otel.GetTextMapPropagator().Fields()
+	if len(fields) != 1 || fields[0] != "baggage" {
+		t.Fatalf("expected global propagator to be unchanged, got fields %v", fields)
+	}
+}
+
+func TestEndpointFromFlags(t *testing.T) {
+	dir := t.TempDir()
+	endpointFile := filepath.Join(dir, "endpoint")
+	if err := os.WriteFile(endpointFile, []byte("  collector-from-file:4317\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missingFile := filepath.Join(dir, "missing")
+
+	for _, tt := range []struct {
+		name        string
+		defaultFile string
+		args        []string
+		expected    string
+		expectErr   bool
+	}{
+		{"flag wins over file", "", []string{"--otel-endpoint=collector:4317", "--otel-endpoint-file=" + endpointFile}, "collector:4317", false},
+		{"file used without flag", "", []string{"--otel-endpoint-file=" + endpointFile}, "collector-from-file:4317", false},
+		{"default file used without flag", endpointFile, nil, "collector-from-file:4317", false},
+		{"nothing set", "", nil, "", false},
+		{"missing default file is skipped", missingFile, nil, "", false},
+		{"missing explicit file errors", "", []string{"--otel-endpoint-file=" + missingFile}, "", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", WithDefaultEndpointFile(tt.defaultFile))
+			cmd := newTestCommand(t, b, tt.args...)
+
+			endpoint, err := b.endpointFromFlags(cmd)
+			if tt.expectErr != (err != nil) {
+				t.Fatalf("unexpected error state: %v", err)
+			}
+			if endpoint != tt.expected {
+				t.Fatalf("expected endpoint %q, got %q", tt.expected, endpoint)
+			}
+		})
+	}
+}
+
+func TestWithEnabledFlag(t *testing.T) {
+	var calls int
+	registerTestProvider(t, "fake-gated", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		calls++
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	for _, tt := range []struct {
+		enabled       string
+		expectedCalls int
+	}{
+		{"false", 0},
+		{"true", 1},
+	} {
+		calls = 0
+		b := New("test", WithEnabledFlag("tracing"))
+		cmd := &cobra.Command{Use: "test"}
+		cmd.Flags().Bool("tracing", true, "")
+		b.RegisterFlags(cmd.Flags())
+		if err := cmd.Flags().Parse([]string{"--otel-provider=fake-gated", "--tracing=" + tt.enabled}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.RunE()(cmd, nil); err != nil {
+			t.Fatalf("RunE failed: %s", err)
+		}
+		if calls != tt.expectedCalls {
+			t.Fatalf("with --tracing=%s expected %d exporters, got %d", tt.enabled, tt.expectedCalls, calls)
+		}
+	}
+
+	b := New("test", WithEnabledFlag("missing"))
+	if err := b.RunE()(newTestCommand(t, b), nil); err == nil {
+		t.Fatal("expected error for a missing enabled flag")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		args        []string
+		sdkDisabled string
+		expected    bool
+	}{
+		{"default", nil, "", false},
+		{"provider", []string{"--otel-provider=otlpgrpc"}, "", true},
+		{"sdk disabled", []string{"--otel-provider=otlpgrpc"}, "true", false},
+		{"sdk disabled case-insensitive", []string{"--otel-provider=otlpgrpc"}, " TRUE ", false},
+		{"sdk not disabled", []string{"--otel-provider=otlpgrpc"}, "false", true},
+		{"sdk disabled with none", []string{"--otel-provider=none"}, "true", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_SDK_DISABLED", tt.sdkDisabled)
+			b := New("test")
+			if got := b.Enabled(newTestCommand(t, b, tt.args...)); got != tt.expected {
+				t.Fatalf("expected enabled to be %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	userAgents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case userAgents <- r.Header.Get("User-Agent"):
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	b := New("test", WithUserAgent("myapp/1.0"))
+	cmd := newTestCommand(t, b,
+		"--otel-provider=otlphttp",
+		"--otel-endpoint="+strings.TrimPrefix(srv.URL, "http://"),
+		"--otel-insecure",
+		"--otel-processor=simple",
+		"--otel-sample-ratio=1",
+	)
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	defer func() { _ = b.Shutdown(context.Background()) }()
+
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	if got := <-userAgents; got != "myapp/1.0" {
+		t.Fatalf("expected user agent myapp/1.0, got %s", got)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	now := time.Unix(0, 0)
+	b := New("test", WithClock(func() time.Time { return now }))
+	cmd := newTestCommand(t, b,
+		"--otel-provider="+provider,
+		"--otel-processor=simple",
+		"--otel-sample-ratio=1",
+		"--otel-max-spans-per-second=1",
+	)
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	startSpans := func(n int) {
+		for i := 0; i < n; i++ {
+			_, span := otel.Tracer("test").Start(context.Background(), "span")
+			span.End()
+		}
+	}
+
+	startSpans(3)
+	if got := len(exporter.GetSpans()); got != 1 {
+		t.Fatalf("expected 1 span before advancing the clock, got %d", got)
+	}
+
+	now = now.Add(time.Second)
+	startSpans(3)
+	if got := len(exporter.GetSpans()); got != 2 {
+		t.Fatalf("expected 2 spans after advancing the clock, got %d", got)
+	}
+}
+
+func TestJaegerProviderUnsupported(t *testing.T) {
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=jaeger")
+
+	err := b.RunE()(cmd, nil)
+	if err == nil || !strings.Contains(err.Error(), "no longer supported") {
+		t.Fatalf("expected unsupported jaeger provider error, got %v", err)
+	}
+}
+
+func TestProviderWhitespace(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider= "+strings.ToUpper(provider)+" ")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if b.tracerProvider == nil {
+		t.Fatal("expected a tracer provider for a space-padded provider")
+	}
+
+	cmd = newTestCommand(t, b, "--otel-provider= jaeger")
+	if err := b.RunE()(cmd, nil); err == nil || !strings.Contains(err.Error(), "no longer supported") {
+		t.Fatalf("expected unsupported jaeger provider error, got %v", err)
+	}
+
+	cmd = newTestCommand(t, b, "--otel-provider= Jaegr")
+	if err := b.RunE()(cmd, nil); err == nil || !strings.Contains(err.Error(), `unknown tracing provider " Jaegr"`) {
+		t.Fatalf("expected the raw unknown provider to be quoted, got %v", err)
+	}
+
+	cmd = newTestCommand(t, b, "--otel-provider=otlpgrpc+ unknown ")
+	if err := b.RunE()(cmd, nil); err == nil || !strings.Contains(err.Error(), `unknown tracing provider " unknown "`) {
+		t.Fatalf("expected the raw unknown provider to be quoted, got %v", err)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders(" api-key = secret ,tenant=a%20b,")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(headers) != 2 || headers["api-key"] != "secret" || headers["tenant"] != "a b" {
+		t.Fatalf("unexpected headers: %v", headers)
+	}
+
+	for _, invalid := range []string{"api-key", "=secret", "api-key=%zz"} {
+		if _, err := parseHeaders(invalid); err == nil {
+			t.Fatalf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestWithHeadersFromEnv(t *testing.T) {
+	t.Setenv("COBRAOTEL_TEST_HEADERS", "api-key=from-env,tenant=from-env")
+
+	b := New("test", WithHeadersFromEnv("COBRAOTEL_TEST_HEADERS"))
+	cmd := newTestCommand(t, b, "--otel-headers=api-key=from-flag")
+
+	headers, err := b.headersFromFlags(cmd)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if headers["api-key"] != "from-flag" || headers["tenant"] != "from-env" {
+		t.Fatalf("expected flag headers to take precedence, got %v", headers)
+	}
+
+	t.Setenv("COBRAOTEL_TEST_HEADERS", "malformed")
+	if err := b.RunE()(cmd, nil); err == nil || !strings.Contains(err.Error(), "COBRAOTEL_TEST_HEADERS") {
+		t.Fatalf("expected wrapped error for malformed headers, got %v", err)
+	}
+}
+
+func TestParseHeadersFile(t *testing.T) {
+	headers, err := parseHeadersFile("# credentials\n\n  api-key = secret \ntenant=a=b\n   # indented comment\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(headers) != 2 || headers["api-key"] != "secret" || headers["tenant"] != "a=b" {
+		t.Fatalf("unexpected headers: %v", headers)
+	}
+
+	for _, invalid := range []string{"api-key", "=secret", "api-key=secret\nmalformed"} {
+		if _, err := parseHeadersFile(invalid); err == nil {
+			t.Fatalf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestHeadersFile(t *testing.T) {
+	t.Setenv("COBRAOTEL_TEST_HEADERS", "api-key=from-env,tenant=from-env,region=from-env")
+
+	headersFile := filepath.Join(t.TempDir(), "headers")
+	if err := os.WriteFile(headersFile, []byte("api-key=from-file\ntenant=from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New("test", WithHeadersFromEnv("COBRAOTEL_TEST_HEADERS"))
+	cmd := newTestCommand(t, b, "--otel-headers-file="+headersFile, "--otel-headers=api-key=from-flag")
+	headers, err := b.headersFromFlags(cmd)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if headers["api-key"] != "from-flag" || headers["tenant"] != "from-file" || headers["region"] != "from-env" {
+		t.Fatalf("expected flag > file > env precedence, got %v", headers)
+	}
+
+	cmd = newTestCommand(t, b, "--otel-headers-file="+filepath.Join(t.TempDir(), "missing"))
+	if _, err := b.headersFromFlags(cmd); err == nil {
+		t.Fatal("expected error for a missing headers file")
+	}
+}
+
+func TestRegisterFlagsTwice(t *testing.T) {
+	flags := pflag.NewFlagSet("shared", pflag.ContinueOnError)
+	New("test").RegisterFlagsWithOptions(flags, FlagProvider)
+	New("test").RegisterFlags(flags)
+	New("other").RegisterFlags(flags)
+
+	if err := flags.Parse([]string{"--otel-provider=otlpgrpc", "--otel-service-name=from-flag"}); err != nil {
+		t.Fatalf("failed to parse flags: %s", err)
+	}
+	if value, _ := flags.GetString("otel-provider"); value != "otlpgrpc" {
+		t.Fatalf("expected provider %q, got %q", "otlpgrpc", value)
+	}
+	if flag := flags.Lookup("otel-service-name"); flag.DefValue != "test" {
+		t.Fatalf("expected the first registration to be kept, got default %q", flag.DefValue)
+	}
+	if !flags.Lookup("otel-jaeger-endpoint").Hidden {
+		t.Fatal("expected legacy flags to remain hidden")
+	}
+}
+
+func TestFlagNames(t *testing.T) {
+	for _, tt := range []struct {
+		prefix     string
+		included   []string
+		excluded   []string
+		deprecated []string
+	}{
+		{
+			prefix:     "otel",
+			included:   []string{"otel-provider", "otel-endpoint", "otel-jaeger-endpoint" + DeprecatedFlagMarker, "otel-jaeger-service-name" + DeprecatedFlagMarker},
+			excluded:   []string{"otel-jaeger-endpoint", "otel-provider" + DeprecatedFlagMarker},
+			deprecated: []string{"otel-jaeger-endpoint", "otel-jaeger-service-name"},
+		},
+		{
+			prefix:     "tracing",
+			included:   []string{"tracing-provider", "tracing-endpoint", "otel-jaeger-endpoint" + DeprecatedFlagMarker},
+			excluded:   []string{"otel-provider", "tracing-jaeger-endpoint" + DeprecatedFlagMarker},
+			deprecated: []string{"otel-jaeger-endpoint", "otel-jaeger-service-name"},
+		},
+	} {
+		t.Run(tt.prefix, func(t *testing.T) {
+			names := FlagNames(tt.prefix)
+			for _, name := range tt.included {
+				if !stringz.SliceContains(names, name) {
+					t.Fatalf("expected %q in %v", name, names)
+				}
+			}
+			for _, name := range tt.excluded {
+				if stringz.SliceContains(names, name) {
+					t.Fatalf("expected %q not to be in %v", name, names)
+				}
+			}
+
+			var deprecated []string
+			for _, name := range names {
+				if trimmed, ok := strings.CutSuffix(name, DeprecatedFlagMarker); ok {
+					deprecated = append(deprecated, trimmed)
+				}
+			}
+			if !reflect.DeepEqual(deprecated, tt.deprecated) {
+				t.Fatalf("expected deprecated flags %v, got %v", tt.deprecated, deprecated)
+			}
+		})
+	}
+}
+
+func TestWithDisableLegacyFlags(t *testing.T) {
+	b := New("test", WithDisableLegacyFlags())
+	cmd := newTestCommand(t, b, "--otel-provider=jaeger")
+
+	if cmd.Flags().Lookup("otel-jaeger-endpoint") != nil || cmd.Flags().Lookup("otel-jaeger-service-name") != nil {
+		t.Fatal("expected legacy flags not to be registered")
+	}
+	if err := b.RunE()(cmd, nil); err == nil {
+		t.Fatal("expected unsupported jaeger provider error")
+	}
+}
+
+func TestLegacyEndpointConflict(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		args        []string
+		expectedLog bool
+	}{
+		{"conflicting values", []string{"--otel-endpoint=collector:4317", "--otel-jaeger-endpoint=jaeger:14268"}, true},
+		{"same values", []string{"--otel-endpoint=collector:4317", "--otel-jaeger-endpoint=collector:4317"}, false},
+		{"only new flag", []string{"--otel-endpoint=collector:4317"}, false},
+		{"only legacy flag", []string{"--otel-jaeger-endpoint=jaeger:14268"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var logged bool
+			logger := funcr.New(func(_, args string) {
+				if strings.Contains(args, "otel-jaeger-endpoint") {
+					logged = true
+				}
+			}, funcr.Options{})
+
+			b := New("test", WithLogger(logger))
+			cmd := newTestCommand(t, b, tt.args...)
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+			if logged != tt.expectedLog {
+				t.Fatalf("expected warning logged to be %t", tt.expectedLog)
+			}
+		})
+	}
+}
+
+func TestPartialFlagRegistration(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	for _, provider := range []string{"jaeger", provider} {
+		b := New("constructor-name")
+		cmd := &cobra.Command{Use: "test"}
+		b.RegisterFlagsWithOptions(cmd.Flags(), FlagProvider|FlagSampling)
+		if err := cmd.Flags().Parse([]string{"--otel-provider=" + provider, "--otel-sample-ratio=1"}); err != nil {
+			t.Fatal(err)
+		}
+
+		err := b.RunE()(cmd, nil)
+		if provider == "jaeger" {
+			if err == nil {
+				t.Fatal("expected unsupported jaeger provider error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("RunE failed: %s", err)
+		}
+
+		_, span := otel.Tracer("test").Start(context.Background(), "span")
+		span.End()
+		if err := b.tracerProvider.ForceFlush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("expected 1 span, got %d", len(spans))
+		}
+		if value, _ := spans[0].Resource.Set().Value("service.name"); value.AsString() != "constructor-name" {
+			t.Fatalf("expected service name to fall back to the constructor, got %q", value.AsString())
+		}
+	}
+}
+
+func TestFlagTypeMismatch(t *testing.T) {
+	b := New("test")
+	cmd := &cobra.Command{Use: "test"}
+	b.RegisterFlagsWithOptions(cmd.Flags(), FlagProvider)
+	cmd.Flags().String("otel-insecure", "", "")
+	if err := cmd.Flags().Parse([]string{"--otel-insecure=yes"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.RunE()(cmd, nil)
+	if err == nil || !strings.Contains(err.Error(), `"otel-insecure"`) {
+		t.Fatalf("expected an error for the mistyped flag, got %v", err)
+	}
+	if described := b.DescribeConfig(cmd); described["error"] == "" {
+		t.Fatalf("expected DescribeConfig to report the mistyped flag, got %v", described)
+	}
+	if env := b.EnvFromFlags(cmd); env != nil {
+		t.Fatalf("expected no environment for the mistyped flag, got %q", env)
+	}
+}
+
+func TestWithServiceNameFromEnvFallback(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		expected    string
+		expectedSet bool
+	}{
+		{"unset", nil, nil, "test", false},
+		{"fallback", map[string]string{"COBRAOTEL_TEST_APP_NAME": "app"}, nil, "app", true},
+		{"order", map[string]string{"COBRAOTEL_TEST_SERVICE_NAME": "service", "COBRAOTEL_TEST_APP_NAME": "app"}, nil, "service", true},
+		{"flag", map[string]string{"COBRAOTEL_TEST_SERVICE_NAME": "service"}, []string{"--otel-service-name=flag"}, "flag", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("COBRAOTEL_TEST_SERVICE_NAME", "")
+			t.Setenv("COBRAOTEL_TEST_APP_NAME", "")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			b := New("test", WithServiceNameFromEnvFallback("COBRAOTEL_TEST_SERVICE_NAME", "COBRAOTEL_TEST_APP_NAME"))
+			cmd := newTestCommand(t, b, tt.args...)
+			name, set := b.serviceNameFromFlags(cmd)
+			if name != tt.expected || set != tt.expectedSet {
+				t.Fatalf("expected service name %q (explicit: %t), got %q (explicit: %t)", tt.expected, tt.expectedSet, name, set)
+			}
+		})
+	}
+}
+
+func TestWithServiceNameFromArg(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	for _, tt := range []struct {
+		name     string
+		index    int
+		flags    []string
+		args     []string
+		expected string
+	}{
+		{"arg", 1, nil, []string{"deploy", "checkout"}, "checkout"},
+		{"missing arg", 1, nil, []string{"deploy"}, "test"},
+		{"negative index", -1, nil, []string{"deploy"}, "test"},
+		{"flag", 0, []string{"--otel-service-name=flag"}, []string{"checkout"}, "flag"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", WithServiceNameFromArg(tt.index))
+			cmd := newTestCommand(t, b, append([]string{"--otel-provider=" + provider}, tt.flags...)...)
+			if err := b.RunE()(cmd, tt.args); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+			if value, _ := b.Resource().Set().Value("service.name"); value.AsString() != tt.expected {
+				t.Fatalf("expected service name %q, got %q", tt.expected, value.AsString())
+			}
+		})
+	}
+}
+
+func TestWithServiceNameFunc(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	var dynamic string
+	b := New("test", WithServiceNameFunc(func() string { return dynamic }))
+	for _, tt := range []struct {
+		dynamic  string
+		flags    []string
+		expected string
+	}{
+		{"", nil, "test"},
+		{"", []string{"--otel-service-name=flag"}, "flag"},
+		{"loaded-from-config", []string{"--otel-service-name=flag"}, "loaded-from-config"},
+	} {
+		dynamic = tt.dynamic
+		cmd := newTestCommand(t, b, append([]string{"--otel-provider=" + provider}, tt.flags...)...)
+		if err := b.RunE()(cmd, nil); err != nil {
+			t.Fatalf("RunE failed: %s", err)
+		}
+		if value, _ := b.Resource().Set().Value("service.name"); value.AsString() != tt.expected {
+			t.Fatalf("expected service name %q, got %q", tt.expected, value.AsString())
+		}
+	}
+}
+
+func TestIsLocalEndpoint(t *testing.T) {
+	for _, tt := range []struct {
+		endpoint string
+		expected bool
+	}{
+		{"localhost:4317", true},
+		{"LOCALHOST", true},
+		{"collector.localhost:4318/v1/traces", true},
+		{"127.0.0.1:4317", true},
+		{"[::1]:4317", true},
+		{"::1", true},
+		{"collector:4317", false},
+		{"10.0.0.1:4317", false},
+		{"localhost.example.com:4317", false},
+		{"unix:///var/run/otel.sock", true},
+		{"dns:///localhost:4317", false},
+	} {
+		if got := isLocalEndpoint(tt.endpoint); got != tt.expected {
+			t.Fatalf("expected isLocalEndpoint(%q) to be %t, got %t", tt.endpoint, tt.expected, got)
+		}
+	}
+}
+
+func TestInsecureFromFlags(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		opts     []Option
+		args     []string
+		expected bool
+	}{
+		{"default", nil, []string{"--otel-endpoint=localhost:4317"}, false},
+		{"default insecure", []Option{WithDefaultInsecure(true)}, []string{"--otel-endpoint=collector:4317"}, true},
+		{"explicit secure", []Option{WithDefaultInsecure(true)}, []string{"--otel-insecure=false"}, false},
+		{"localhost", []Option{WithInsecureLocalhost()}, []string{"--otel-endpoint=localhost:4317"}, true},
+		{"remote", []Option{WithInsecureLocalhost()}, []string{"--otel-endpoint=collector:4317"}, false},
+		{"mixed", []Option{WithInsecureLocalhost()}, []string{"--otel-endpoint=localhost:4317,collector:4317"}, false},
+		{"localhost explicit secure", []Option{WithInsecureLocalhost()}, []string{"--otel-endpoint=localhost:4317", "--otel-insecure=false"}, false},
+		{"localhost with tls", []Option{WithInsecureLocalhost()}, []string{"--otel-endpoint=localhost:4317", "--otel-tls-server-name=collector"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", tt.opts...)
+			cmd := newTestCommand(t, b, tt.args...)
+			endpoint, err := b.endpointFromFlags(cmd)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := b.insecureFromFlags(cmd, endpoint); got != tt.expected {
+				t.Fatalf("expected insecure to be %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
+
+// slowShutdownExporter is a SpanExporter whose Shutdown blocks until release
+// is closed, regardless of its context.
+type slowShutdownExporter struct {
+	*tracetest.InMemoryExporter
+	release chan struct{}
+}
+
+func (e *slowShutdownExporter) Shutdown(ctx context.Context) error {
+	<-e.release
+	return e.InMemoryExporter.Shutdown(ctx)
+}
+
+// shutdownRecordingExporter is a SpanExporter signaling every call to
+// Shutdown on shutdowns.
+type shutdownRecordingExporter struct {
+	*tracetest.InMemoryExporter
+	shutdowns chan struct{}
+}
+
+func newShutdownRecordingExporter() *shutdownRecordingExporter {
+	return &shutdownRecordingExporter{
+		InMemoryExporter: tracetest.NewInMemoryExporter(),
+		shutdowns:        make(chan struct{}, 8),
+	}
+}
+
+func (e *shutdownRecordingExporter) Shutdown(ctx context.Context) error {
+	e.shutdowns <- struct{}{}
+	return e.InMemoryExporter.Shutdown(ctx)
+}
+
+func TestWithShutdownOnContext(t *testing.T) {
+	var exporter *shutdownRecordingExporter
+	registerTestProvider(t, "fake-shutdown-on-context", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		exporter = newShutdownRecordingExporter()
+		return exporter, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := New("test", WithShutdownOnContext(ctx))
+	cmd := newTestCommand(t, b, "--otel-provider=fake-shutdown-on-context")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	cancel()
+	select {
+	case <-exporter.shutdowns:
+	case <-time.After(time.Second):
+		t.Fatal("expected cancelling the context to shut down the tracer provider")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	var exporter *slowShutdownExporter
+	registerTestProvider(t, "fake-slow-shutdown", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		return exporter, nil
+	})
+
+	for _, tt := range []struct {
+		name          string
+		dropOnTimeout bool
+	}{
+		{"drop", true},
+		{"wait", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter = &slowShutdownExporter{InMemoryExporter: tracetest.NewInMemoryExporter(), release: make(chan struct{})}
+			defer close(exporter.release)
+
+			b := New("test")
+			cmd := newTestCommand(t, b,
+				"--otel-provider=fake-slow-shutdown",
+				"--otel-shutdown-timeout=10ms",
+				fmt.Sprintf("--otel-shutdown-drop-on-timeout=%t", tt.dropOnTimeout),
+			)
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+
+			errs := make(chan error, 1)
+			go func() { errs <- b.Shutdown(context.Background()) }()
+
+			select {
+			case err := <-errs:
+				if !tt.dropOnTimeout {
+					t.Fatal("expected Shutdown to wait for the exporter")
+				}
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("expected deadline exceeded error, got %v", err)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.dropOnTimeout {
+					t.Fatal("expected Shutdown to return once the timeout elapsed")
+				}
+				exporter.release <- struct{}{}
+				if err := <-errs; err != nil {
+					t.Fatalf("unexpected shutdown error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestExistingGlobalTracerProvider(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	for _, tt := range []struct {
+		name       string
+		opts       []Option
+		expectKeep bool
+	}{
+		{"override", nil, false},
+		{"respect", []Option{WithRespectExistingGlobal()}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", tt.opts...)
+			cmd := newTestCommand(t, b, "--otel-provider="+provider)
+
+			existing := trace.NewTracerProvider()
+			otel.SetTracerProvider(existing)
+
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+			if kept := otel.GetTracerProvider() == existing; kept != tt.expectKeep {
+				t.Fatalf("expected existing global tracer provider to be kept: %t", tt.expectKeep)
+			}
+
+			// Reconfiguring replaces the provider installed by RunE().
+			if !tt.expectKeep {
+				if err := b.RunE()(cmd, nil); err != nil {
+					t.Fatalf("RunE failed: %s", err)
+				}
+				if otel.GetTracerProvider() != b.tracerProvider {
+					t.Fatal("expected the previously installed tracer provider to be replaced")
+				}
+			}
+		})
+	}
+}
+
+func TestIsExternalTracerProvider(t *testing.T) {
+	t.Cleanup(ResetGlobalsForTest)
+
+	installed := trace.NewTracerProvider()
+	setGlobalTracerProvider(installed)
+	for _, tt := range []struct {
+		name     string
+		tp       oteltrace.TracerProvider
+		expected bool
+	}{
+		{"default", defaultGlobalTracerProvider, false},
+		{"noop", oteltrace.NewNoopTracerProvider(), false},
+		{"installed", installed, false},
+		{"external", trace.NewTracerProvider(), true},
+	} {
+		if got := isExternalTracerProvider(tt.tp); got != tt.expected {
+			t.Fatalf("%s: expected %t, got %t", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestRespectExistingGlobalMultipleBuilders(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	var logs []string
+	logger := funcr.New(func(prefix, args string) { logs = append(logs, args) }, funcr.Options{Verbosity: 1})
+
+	first := New("first")
+	if err := first.RunE()(newTestCommand(t, first, "--otel-provider="+provider), nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	second := New("second", WithLogger(logger), WithRespectExistingGlobal())
+	if err := second.RunE()(newTestCommand(t, second, "--otel-provider="+provider), nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if otel.GetTracerProvider() != second.tracerProvider {
+		t.Fatal("expected the tracer provider installed by another Builder to be replaced")
+	}
+	for _, log := range logs {
+		if strings.Contains(log, "existing global tracer provider") {
+			t.Fatalf("expected the tracer provider installed by another Builder not to be treated as external, got %s", log)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	for _, tt := range []struct {
+		name        string
+		env         string
+		args        []string
+		expectError bool
+	}{
+		{"missing endpoint", "", []string{"--otel-provider=" + provider, "--otel-strict"}, true},
+		{"endpoint flag", "", []string{"--otel-provider=" + provider, "--otel-strict", "--otel-endpoint=collector:4317"}, false},
+		{"endpoint env", "collector:4317", []string{"--otel-provider=" + provider, "--otel-strict"}, false},
+		{"none provider", "", []string{"--otel-strict"}, false},
+		{"not strict", "", []string{"--otel-provider=" + provider}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", tt.env)
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+			b := New("test")
+			cmd := newTestCommand(t, b, tt.args...)
+			if err := b.RunE()(cmd, nil); (err != nil) != tt.expectError {
+				t.Fatalf("expected error: %t, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestRequireCA(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_CERTIFICATE", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_CERTIFICATE", "")
+
+	for _, tt := range []struct {
+		name        string
+		args        []string
+		env         string
+		expectError bool
+	}{
+		{"disabled", []string{"--otel-endpoint=https://collector:4318"}, "", false},
+		{"missing ca", []string{"--otel-endpoint=https://collector:4318", "--otel-require-ca"}, "", true},
+		{"ca from env", []string{"--otel-endpoint=https://collector:4318", "--otel-require-ca"}, "/etc/ssl/private-ca.pem", false},
+		{"insecure", []string{"--otel-endpoint=collector:4318", "--otel-require-ca", "--otel-insecure"}, "", false},
+		{"skip verify", []string{"--otel-endpoint=https://collector:4318", "--otel-require-ca", "--otel-tls-insecure-skip-verify"}, "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_EXPORTER_OTLP_TRACES_CERTIFICATE", tt.env)
+
+			b := New("test")
+			cmd := newTestCommand(t, b, append([]string{"--otel-provider=otlphttp"}, tt.args...)...)
+			_, err := b.configFromFlags(cmd, nil)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("expected error: %t, got %v", tt.expectError, err)
+			}
+		})
+	}
+}
+
+func TestSkipAnnotation(t *testing.T) {
+	var called bool
+	registerTestProvider(t, "fake-skip", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		called = true
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=fake-skip")
+	cmd.Annotations = map[string]string{SkipAnnotation: "true"}
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if called || b.tracerProvider != nil {
+		t.Fatal("expected annotated command to be skipped")
+	}
+
+	cmd.Annotations[SkipAnnotation] = "false"
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if !called {
+		t.Fatal("expected command annotated with false not to be skipped")
+	}
+}
+
+func TestCompletionCommands(t *testing.T) {
+	var called bool
+	registerTestProvider(t, "fake-completion", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		called = true
+		return tracetest.NewInMemoryExporter(), nil
+	})
+
+	for _, tt := range []struct {
+		name           string
+		args           []string
+		expectedCalled bool
+	}{
+		{"command", []string{"serve"}, true},
+		{"__complete", []string{cobra.ShellCompRequestCmd, "serve", ""}, false},
+		{"__completeNoDesc", []string{cobra.ShellCompNoDescRequestCmd, "serve", ""}, false},
+		{"completion", []string{"completion", "bash"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(ResetGlobalsForTest)
+			called = false
+
+			b := New("test")
+			root := &cobra.Command{Use: "test", PersistentPreRunE: b.RunE()}
+			b.RegisterFlags(root.PersistentFlags())
+			if err := root.PersistentFlags().Set("otel-provider", "fake-completion"); err != nil {
+				t.Fatalf("failed to set provider: %s", err)
+			}
+			root.AddCommand(&cobra.Command{Use: "serve", Run: func(*cobra.Command, []string) {}})
+			root.SetArgs(tt.args)
+			root.SetOut(io.Discard)
+			if err := root.Execute(); err != nil {
+				t.Fatalf("failed to execute command: %s", err)
+			}
+
+			if called != tt.expectedCalled || (b.tracerProvider != nil) != tt.expectedCalled {
+				t.Fatalf("expected a tracer provider to be installed: %t", tt.expectedCalled)
+			}
+		})
+	}
+}
+
+func TestWithScopeName(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	var modulePath string
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		modulePath = bi.Main.Path
+	}
+
+	for _, tt := range []struct {
+		name          string
+		opts          []Option
+		tracerName    string
+		expectedScope string
+	}{
+		{"module path fallback", nil, "", modulePath},
+		{"default scope name", []Option{WithScopeName("example.com/tool")}, "", "example.com/tool"},
+		{"explicit name", []Option{WithScopeName("example.com/tool")}, "example.com/tool/db", "example.com/tool/db"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			b := New("test", tt.opts...)
+			cmd := newTestCommand(t, b, "--otel-provider="+provider, "--otel-processor=simple", "--otel-sample-ratio=1")
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+
+			_, span := b.Tracer(tt.tracerName).Start(context.Background(), "work")
+			span.End()
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			if scope := spans[0].InstrumentationLibrary.Name; scope != tt.expectedScope {
+				t.Fatalf("expected scope name %q, got %q", tt.expectedScope, scope)
+			}
+		})
+	}
+}
+
+func TestChild(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	parent := New("parent", WithFlagPrefix("tracing"), WithSampler(trace.AlwaysSample()))
+	child := parent.Child(WithServiceName("child"))
+
+	root := &cobra.Command{Use: "root", RunE: func(*cobra.Command, []string) error { return nil }}
+	parent.RegisterFlags(root.PersistentFlags())
+	root.PersistentPreRunE = parent.RunE()
+
+	sub := &cobra.Command{
+		Use: "sub",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, span := child.Tracer("test").Start(context.Background(), "work")
+			span.End()
+			return nil
+		},
+	}
+	child.RegisterFlags(sub.Flags())
+	sub.PreRunE = child.RunE()
+	root.AddCommand(sub)
+
+	root.SetArgs([]string{"sub", "--tracing-provider=" + provider, "--tracing-processor=simple"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("failed to execute: %s", err)
+	}
+
+	if flag := root.PersistentFlags().Lookup("tracing-service-name"); flag.DefValue != "parent" {
+		t.Fatalf("expected the parent service name to be unchanged, got %q", flag.DefValue)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected the inherited sampler to sample 1 span, got %d", len(spans))
+	}
+	if value, _ := spans[0].Resource.Set().Value("service.name"); value.AsString() != "child" {
+		t.Fatalf("expected service name %q, got %q", "child", value.AsString())
+	}
+}
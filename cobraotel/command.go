@@ -0,0 +1,160 @@
+package cobraotel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jzelinskie/cobrautil/v2"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer used for spans emitted by
+// this package.
+const instrumentationName = "github.com/jzelinskie/cobrautil/v2/cobraotel"
+
+const (
+	cliCommandKey  = attribute.Key("cli.command")
+	cliArgsKey     = attribute.Key("cli.args")
+	cliExitCodeKey = attribute.Key("cli.exit_code")
+)
+
+// startCommandSpan starts the span enabled by "$PREFIX-trace-command" and
+// makes it the parent of spans started from the context of the command.
+func (b *Builder) startCommandSpan(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if b.argsRedactor != nil {
+		args = b.argsRedactor(args)
+	}
+	opts := []oteltrace.SpanStartOption{
+		oteltrace.WithSpanKind(oteltrace.SpanKindInternal),
+		oteltrace.WithAttributes(
+			cliCommandKey.String(cmd.CommandPath()),
+			cliArgsKey.StringSlice(args),
+		),
+	}
+	opts = append(opts, b.commandSpanOpts...)
+	ctx, span := b.Tracer(instrumentationName).Start(ctx, cmd.CommandPath(), opts...)
+	cmd.SetContext(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commandSpan = span
+}
+
+// endCommandSpan ends the span started by startCommandSpan, if any,
+// recording the provided error.
+func (b *Builder) endCommandSpan(err error) {
+	b.mu.Lock()
+	span := b.commandSpan
+	b.commandSpan = nil
+	b.mu.Unlock()
+	if span == nil {
+		return
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(cliExitCodeKey.Int(1))
+	} else {
+		span.SetStatus(codes.Ok, "")
+		span.SetAttributes(cliExitCodeKey.Int(0))
+	}
+	span.End()
+}
+
+// PostRunE returns a Cobra run func that ends the span emitted for the
+// command by "$PREFIX-trace-command", flushes the spans of the tracer
+// provider installed by RunE() and logs the number of spans exported so
+// far, and the time spent exporting them, at the pre-run level.
+//
+// It is meant to be used as the PersistentPostRunE of the root command and
+// does nothing for the "none" provider. Failing to flush is logged rather
+// than returned, so that tracing does not fail the command.
+//
+// Cobra does not run post-run funcs when a command fails: use WrapRunE to
+// record errors. Any command span still in progress is otherwise ended by
+// Shutdown.
+func (b *Builder) PostRunE() cobrautil.CobraRunFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		b.endCommandSpan(nil)
+		b.mu.Lock()
+		tracerProvider, stats := b.tracerProvider, b.exportStats
+		b.mu.Unlock()
+		if tracerProvider == nil {
+			return nil
+		}
+
+		ctx := cmd.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if err := tracerProvider.ForceFlush(ctx); err != nil {
+			b.logger.Error(err, "failed to flush opentelemetry spans")
+		}
+
+		if stats != nil {
+			b.logger.V(b.preRunLevel).Info(
+				"exported opentelemetry spans",
+				"spans", stats.spans.Load(),
+				"failedSpans", stats.failedSpans.Load(),
+				"duration", time.Duration(stats.duration.Load()),
+			)
+		}
+		return nil
+	}
+}
+
+// WrapRunE wraps the RunE of a command such that an error it returns is
+// recorded on the span emitted for the command by "$PREFIX-trace-command".
+//
+// With WithExportOnPanic, spans are also flushed when the command panics.
+func (b *Builder) WrapRunE(run cobrautil.CobraRunFunc) cobrautil.CobraRunFunc {
+	return func(cmd *cobra.Command, args []string) error {
+		if b.exportOnPanic {
+			defer func() {
+				if r := recover(); r != nil {
+					b.flushOnPanic(cmd, r)
+					panic(r)
+				}
+			}()
+		}
+
+		err := run(cmd, args)
+		if err != nil {
+			b.endCommandSpan(err)
+		}
+		return err
+	}
+}
+
+// flushOnPanic records a panic on the command span and flushes the spans of
+// the tracer provider installed by RunE(), bounded by the shutdown timeout.
+func (b *Builder) flushOnPanic(cmd *cobra.Command, r any) {
+	b.endCommandSpan(fmt.Errorf("panic: %v", r))
+	tracerProvider, shutdown := b.currentTracerProvider()
+	if tracerProvider == nil {
+		return
+	}
+
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if shutdown.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, shutdown.timeout)
+		defer cancel()
+	}
+	if err := tracerProvider.ForceFlush(ctx); err != nil {
+		b.logger.Error(err, "failed to flush opentelemetry spans after a panic")
+	}
+}
@@ -0,0 +1,213 @@
+package cobraotel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceCommand(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	for _, tt := range []struct {
+		name         string
+		err          error
+		expectedCode codes.Code
+	}{
+		{"success", nil, codes.Ok},
+		{"failure", errors.New("failed"), codes.Error},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			b := New("test", WithArgsRedactor(func(args []string) []string {
+				return []string{args[0], "REDACTED"}
+			}))
+			cmd := newTestCommand(t, b,
+				"--otel-provider="+provider,
+				"--otel-trace-command",
+				"--otel-processor=simple",
+				"--otel-sample-ratio=1",
+			)
+			args := []string{"user", "password"}
+			if err := b.RunE()(cmd, args); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+
+			run := b.WrapRunE(func(cmd *cobra.Command, args []string) error {
+				_, span := b.Tracer("test").Start(cmd.Context(), "child")
+				span.End()
+				return tt.err
+			})
+			if err := run(cmd, args); err == nil {
+				if err := b.PostRunE()(cmd, args); err != nil {
+					t.Fatalf("PostRunE failed: %s", err)
+				}
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 2 {
+				t.Fatalf("expected 2 spans, got %d", len(spans))
+			}
+			child, root := spans[0], spans[1]
+			if root.Name != "test" || root.Status.Code != tt.expectedCode {
+				t.Fatalf("unexpected command span %q with status %v", root.Name, root.Status.Code)
+			}
+			if child.Parent.SpanID() != root.SpanContext.SpanID() {
+				t.Fatal("expected the command span to be the parent of spans started by the command")
+			}
+
+			attrs := attribute.NewSet(root.Attributes...)
+			if value, _ := attrs.Value(cliArgsKey); !reflect.DeepEqual(value.AsStringSlice(), []string{"user", "REDACTED"}) {
+				t.Fatalf("expected redacted args, got %v", value.AsStringSlice())
+			}
+			if value, _ := attrs.Value(cliExitCodeKey); tt.err != nil && value.AsInt64() != 1 {
+				t.Fatalf("expected exit code 1, got %d", value.AsInt64())
+			}
+		})
+	}
+}
+
+func TestTraceCommandDisabled(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider="+provider)
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if span := oteltrace.SpanFromContext(cmd.Context()); span.SpanContext().IsValid() {
+		t.Fatal("expected no command span")
+	}
+}
+
+func TestWithCommandSpanOptions(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	for _, tt := range []struct {
+		name         string
+		opts         []oteltrace.SpanStartOption
+		expectedKind oteltrace.SpanKind
+		expectedTool string
+	}{
+		{"defaults", nil, oteltrace.SpanKindInternal, ""},
+		{"custom", []oteltrace.SpanStartOption{
+			oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+			oteltrace.WithAttributes(attribute.String("tool", "deployer")),
+		}, oteltrace.SpanKindClient, "deployer"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			b := New("test", WithCommandSpanOptions(tt.opts...))
+			cmd := newTestCommand(t, b,
+				"--otel-provider="+provider,
+				"--otel-trace-command",
+				"--otel-processor=simple",
+				"--otel-sample-ratio=1",
+			)
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+			if err := b.PostRunE()(cmd, nil); err != nil {
+				t.Fatalf("PostRunE failed: %s", err)
+			}
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected 1 span, got %d", len(spans))
+			}
+			if spans[0].SpanKind != tt.expectedKind {
+				t.Fatalf("expected span kind %s, got %s", tt.expectedKind, spans[0].SpanKind)
+			}
+
+			attrs := attribute.NewSet(spans[0].Attributes...)
+			if value, _ := attrs.Value("tool"); value.AsString() != tt.expectedTool {
+				t.Fatalf("expected tool %q, got %q", tt.expectedTool, value.AsString())
+			}
+			if value, _ := attrs.Value(cliCommandKey); value.AsString() != "test" {
+				t.Fatalf("expected command %q, got %q", "test", value.AsString())
+			}
+		})
+	}
+}
+
+func TestPostRunEFlushes(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	var logged []string
+	logger := funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{})
+
+	b := New("test", WithLogger(logger))
+	cmd := newTestCommand(t, b, "--otel-provider=none")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if err := b.PostRunE()(cmd, nil); err != nil {
+		t.Fatalf("PostRunE failed: %s", err)
+	}
+	for _, line := range logged {
+		if strings.Contains(line, "exported opentelemetry spans") {
+			t.Fatalf("expected no stats for the none provider, got %s", line)
+		}
+	}
+
+	cmd = newTestCommand(t, b, "--otel-provider="+provider, "--otel-sample-ratio=1")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	_, span := b.Tracer("test").Start(context.Background(), "work")
+	span.End()
+
+	if err := b.PostRunE()(cmd, nil); err != nil {
+		t.Fatalf("PostRunE failed: %s", err)
+	}
+	if spans := exporter.GetSpans(); len(spans) != 1 {
+		t.Fatalf("expected the batched span to be flushed, got %d spans", len(spans))
+	}
+	if last := logged[len(logged)-1]; !strings.Contains(last, `"msg"="exported opentelemetry spans"`) || !strings.Contains(last, `"spans"=1`) {
+		t.Fatalf("expected export stats to be logged, got %s", last)
+	}
+}
+
+func TestWithExportOnPanic(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	b := New("test", WithExportOnPanic())
+	cmd := newTestCommand(t, b, "--otel-provider="+provider, "--otel-trace-command", "--otel-sample-ratio=1")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	run := b.WrapRunE(func(cmd *cobra.Command, args []string) error {
+		_, span := b.Tracer("test").Start(cmd.Context(), "child")
+		span.End()
+		panic("boom")
+	})
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("expected the panic to propagate, got %v", r)
+			}
+		}()
+		_ = run(cmd, nil)
+	}()
+
+	// The batch processor only exports on flush, so the spans were flushed
+	// by the panic.
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 flushed spans, got %d", len(spans))
+	}
+	for _, span := range spans {
+		if span.Name == cmd.CommandPath() && (span.Status.Code != codes.Error || span.Status.Description != "panic: boom") {
+			t.Fatalf("expected the command span to record the panic, got %+v", span.Status)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package cobraotel
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// Config is the configuration used to install a tracer provider.
+//
+// RunE() reads it from flags; it can also be provided directly to
+// Configure by programs that are not configured with flags. The zero value
+// of every field matches the default value of the corresponding flag.
+type Config struct {
+	// Provider is the name of the tracing provider, e.g. "otlpgrpc". It
+	// defaults to "none", which does not install a tracer provider.
+	//
+	// The "auto" provider is inferred from the endpoint: a "grpc://",
+	// "unix://" or "dns://" scheme or port 4317 selects "otlpgrpc", while an
+	// "http://" or "https://" scheme, port 4318 or a URL path selects
+	// "otlphttp". Other endpoints are ambiguous and return an error.
+	//
+	// The "otlp" provider is selected by the OTEL_EXPORTER_OTLP_TRACES_PROTOCOL
+	// or OTEL_EXPORTER_OTLP_PROTOCOL environment variable: "grpc" (the
+	// default) selects "otlpgrpc" and "http/protobuf" selects "otlphttp".
+	//
+	// Multiple providers separated by "+", e.g. "otlphttp+otlpgrpc", export
+	// every span to each of them.
+	Provider string
+
+	// Endpoint is the collector endpoint. Multiple endpoints separated by
+	// commas are failed over between.
+	//
+	// With multiple providers, the endpoints of each provider can be
+	// separated by "+" in the same order, e.g. "old:4318+new:4317";
+	// otherwise every provider uses the same endpoint.
+	Endpoint string
+
+	// URLPath overrides the URL path traces are exported to by the
+	// "otlphttp" provider.
+	URLPath string
+
+	// Insecure connects to the collector in plaintext. It cannot be combined
+	// with TLSConfig.
+	Insecure bool
+
+	// Headers are sent with every export request.
+	Headers map[string]string
+
+	// TLSConfig overrides the TLS configuration used to reach the collector.
+	TLSConfig *tls.Config
+
+	// ConnectTimeout bounds the time spent creating the exporter, e.g. by a
+	// provider registered with RegisterProvider that dials the collector. The
+	// built-in OTLP exporters connect lazily, so it does not detect an
+	// unreachable collector for them. It defaults to 10s; a negative value
+	// disables it.
+	ConnectTimeout time.Duration
+
+	// ServiceName overrides the service name provided to New.
+	ServiceName string
+
+	// ResourceAttributes are added to the resource attached to every span.
+	ResourceAttributes []attribute.KeyValue
+
+	// Propagators are the names of the trace propagation formats, e.g.
+	// "b3". They default to "w3c".
+	Propagators []string
+
+	// Sampler samples traces. It defaults to sampling 1% of the traces that
+	// are not part of a sampled trace.
+	Sampler trace.Sampler
+
+	// Processor is the span processor used to export spans: "batch" (the
+	// default) or "simple".
+	Processor string
+
+	// BlockOnFull blocks instead of dropping spans when the queue of the
+	// batch processor is full.
+	BlockOnFull bool
+
+	// MaxExportBatchSize is the maximum number of spans exported at once by
+	// the batch processor, or 0 for the default.
+	MaxExportBatchSize int
+
+	// ExportErrorsOnly and ExportMinDuration filter the spans that are
+	// exported: when either is set, only spans with an error status or
+	// lasting at least ExportMinDuration are exported.
+	ExportErrorsOnly  bool
+	ExportMinDuration time.Duration
+
+	// OmitBuildInfo omits the service version and VCS attributes of the
+	// binary from the resource.
+	OmitBuildInfo bool
+
+	// ProcessStartTime adds the start time of the process to the resource.
+	ProcessStartTime bool
+
+	// SemconvVersion selects the semantic convention version, e.g. "1.21.0",
+	// used for the schema URL of the resource. It defaults to the version
+	// used by the OpenTelemetry SDK.
+	SemconvVersion string
+
+	// Debug logs the resolved configuration and the debug messages of the
+	// OpenTelemetry SDK to stderr, independently of WithLogger. Header values
+	// are redacted.
+	Debug bool
+
+	// SpanLimits bounds the attributes, events and links recorded by spans.
+	SpanLimits SpanLimits
+
+	// StartupRetries is the number of times creating the exporters is retried
+	// on startup, after StartupRetryDelay jittered by the strategy provided
+	// to WithStartupRetryJitter. StartupRetryDelay defaults to 1s.
+	StartupRetries    int
+	StartupRetryDelay time.Duration
+
+	// ShutdownTimeout bounds the time spent flushing spans on shutdown, after
+	// which unflushed spans are dropped unless ShutdownWaitOnTimeout is set.
+	ShutdownTimeout       time.Duration
+	ShutdownWaitOnTimeout bool
+}
+
+// Configure installs a tracer provider for the provided configuration, like
+// RunE() does for the configuration read from flags.
+//
+// Configuring a builder again replaces its tracer provider and shuts down the
+// previous one; configuring the "none" provider also replaces it as the
+// global tracer provider with a no-op one. The returned func shuts down the
+// tracer provider; see Shutdown.
+//
+// Configure is safe to call concurrently with the use of the builder, e.g.
+// with Tracer.
+func (b *Builder) Configure(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	b.configureMu.Lock()
+	defer b.configureMu.Unlock()
+
+	var noLogger, debugLogger logr.Logger
+	if cfg.Debug {
+		debugLogger = newDebugLogger()
+	}
+	switch {
+	case b.droppedSpanCallback != nil:
+		otel.SetLogger(b.droppedSpanLogger(debugLogger))
+	case cfg.Debug:
+		otel.SetLogger(debugLogger)
+	case b.logger != noLogger:
+		otel.SetLogger(b.logger)
+	}
+
+	if err := validateProviders(cfg.Provider); err != nil {
+		return nil, err
+	}
+	provider := strings.ToLower(strings.TrimSpace(cfg.Provider))
+	if provider == "" {
+		provider = defaultProvider
+	}
+	processor := strings.ToLower(strings.TrimSpace(cfg.Processor))
+	if processor == "" {
+		processor = defaultProcessor
+	}
+	if processor != "batch" && processor != "simple" {
+		return nil, fmt.Errorf("unknown span processor: %s", processor)
+	}
+	if cfg.Insecure && cfg.TLSConfig != nil {
+		return nil, errors.New("failed to configure opentelemetry: Insecure and TLSConfig are mutually exclusive")
+	}
+	propagators := normalizePropagators(cfg.Propagators)
+	sampler := cfg.Sampler
+	if sampler == nil {
+		sampler = newSampler(samplerConfig{ratio: defaultSampleRatio, now: b.now})
+	}
+	schemaURL, err := semconvSchemaURL(cfg.SemconvVersion)
+	if err != nil {
+		return nil, err
+	}
+	if provider, err = b.resolveProvider(provider, cfg.Endpoint); err != nil {
+		return nil, err
+	}
+	if b.traceStateKey != "" || b.traceStateValue != "" {
+		if _, err := (oteltrace.TraceState{}).Insert(b.traceStateKey, b.traceStateValue); err != nil {
+			return nil, fmt.Errorf("invalid tracestate member: %w", err)
+		}
+	}
+	spanLimits := cfg.SpanLimits.resolve()
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = b.serviceName
+	}
+
+	endpoint, err := normalizeEndpoints(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid opentelemetry endpoint: %w", err)
+	}
+	if endpoint != cfg.Endpoint {
+		b.logger.V(b.preRunLevel).Info("normalized opentelemetry endpoint", "endpoint", redactEndpoints(endpoint))
+	}
+
+	if cfg.URLPath != "" && provider == "otlpgrpc" {
+		b.logger.V(b.preRunLevel).Info("ignoring traces path for otlpgrpc provider", "path", cfg.URLPath)
+	}
+
+	if b.setupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.setupTimeout)
+		defer cancel()
+	}
+
+	connectTimeout := cfg.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	retryDelay := cfg.StartupRetryDelay
+	if retryDelay <= 0 {
+		retryDelay = defaultStartupRetryDelay
+	}
+
+	exporterOpts := ExporterOptions{
+		Endpoint:  endpoint,
+		URLPath:   cfg.URLPath,
+		Insecure:  cfg.Insecure,
+		Headers:   cfg.Headers,
+		UserAgent: b.userAgent,
+		TLSConfig: cfg.TLSConfig,
+	}
+	exporters, err := b.newExporters(ctx, provider, exporterOpts, connectTimeout)
+	for attempt := 1; err != nil && attempt <= cfg.StartupRetries && ctx.Err() == nil; attempt++ {
+		delay := b.startupRetryJitter(retryDelay)
+		b.logger.V(b.preRunLevel).Info("retrying opentelemetry exporter setup", "attempt", attempt, "delay", delay, "error", err.Error())
+		if sleepErr := b.sleep(ctx, delay); sleepErr != nil {
+			break
+		}
+		exporters, err = b.newExporters(ctx, provider, exporterOpts, connectTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(exporters) > 0 {
+		if b.keepSlowSpans > 0 {
+			sampler = recordDroppedRoots(sampler)
+		}
+		if b.configuredSpan {
+			sampler = configuredSpanSampler{Sampler: sampler}
+		}
+		if b.traceStateKey != "" || b.traceStateValue != "" {
+			sampler = traceStateSampler{Sampler: sampler, key: b.traceStateKey, value: b.traceStateValue}
+		}
+
+		stats := &exportStats{}
+		for i, exporter := range exporters {
+			if b.wrapper != nil {
+				exporter = b.wrapper(exporter)
+			}
+			if b.backpressureCallback != nil {
+				exporter = &backpressureExporter{
+					SpanExporter: exporter,
+					threshold:    b.backpressureThreshold,
+					callback:     b.backpressureCallback,
+				}
+			}
+			if b.exportErrorHandler != nil {
+				exporter = &errorHandlerExporter{SpanExporter: exporter, handler: b.exportErrorHandler}
+			}
+			exporters[i] = &statsExporter{SpanExporter: exporter, stats: stats}
+		}
+
+		if err := b.initOtelTracer(ctx, exporters, tracerConfig{
+			serviceName:        serviceName,
+			serviceNameSet:     cfg.ServiceName != "",
+			resourceAttrs:      cfg.ResourceAttributes,
+			propagators:        propagators,
+			sampler:            sampler,
+			spanLimits:         spanLimits,
+			processor:          processor,
+			blockOnFull:        cfg.BlockOnFull,
+			maxExportBatchSize: cfg.MaxExportBatchSize,
+			exportErrorsOnly:   cfg.ExportErrorsOnly,
+			exportMinDuration:  cfg.ExportMinDuration,
+			buildInfo:          !cfg.OmitBuildInfo,
+			processStartTime:   cfg.ProcessStartTime,
+			schemaURL:          schemaURL,
+
+			exportStats:           stats,
+			shutdownTimeout:       cfg.ShutdownTimeout,
+			shutdownDropOnTimeout: !cfg.ShutdownWaitOnTimeout,
+		}); err != nil {
+			return nil, setupError(ctx, err)
+		}
+
+		if b.configuredSpan {
+			spanCtx := context.WithValue(ctx, configuredSpanKey{}, true)
+			_, span := b.Tracer(instrumentationName).Start(spanCtx, configuredSpanName, oteltrace.WithNewRoot(), oteltrace.WithAttributes(
+				attribute.String("otel.provider", provider),
+				attribute.String("otel.endpoint", redactEndpoints(endpoint)),
+				attribute.String("otel.processor", processor),
+				attribute.StringSlice("otel.propagators", propagators),
+				attribute.String("otel.sampler", sampler.Description()),
+			))
+			span.End()
+		}
+	} else {
+		b.uninstallTracerProvider(ctx)
+		if b.installNoopOnNone {
+			b.installNoop()
+		}
+	}
+
+	if cfg.Debug {
+		debugLogger.Info(
+			"resolved opentelemetry configuration",
+			"provider", provider,
+			"exporters", exporterTypes(exporters...),
+			"endpoint", redactEndpoints(endpoint),
+			"urlPath", cfg.URLPath,
+			"insecure", cfg.Insecure,
+			"headers", redactedHeaders(cfg.Headers),
+			"customTLS", cfg.TLSConfig != nil,
+			"service", serviceName,
+			"propagators", propagators,
+			"sampler", sampler.Description(),
+			"processor", processor,
+			"blockOnFull", cfg.BlockOnFull,
+			"maxExportBatchSize", cfg.MaxExportBatchSize,
+			"exportErrorsOnly", cfg.ExportErrorsOnly,
+			"exportMinDuration", cfg.ExportMinDuration,
+			"semconvVersion", cfg.SemconvVersion,
+			"spanLimits", spanLimits,
+			"shutdownTimeout", cfg.ShutdownTimeout,
+		)
+	}
+
+	b.logger.V(b.preRunLevel).Info(
+		"configured opentelemetry tracing",
+		"provider", provider,
+		"endpoint", redactEndpoints(endpoint),
+		"service", serviceName,
+		"insecure", cfg.Insecure,
+		"sampler", sampler.Description(),
+		"processor", processor,
+		"blockOnFull", cfg.BlockOnFull,
+		"maxExportBatchSize", cfg.MaxExportBatchSize,
+	)
+	return b.Shutdown, nil
+}
+
+// newExporters constructs the exporters for provider, bounded by the connect
+// timeout.
+func (b *Builder) newExporters(ctx context.Context, provider string, opts ExporterOptions, connectTimeout time.Duration) ([]trace.SpanExporter, error) {
+	connectCtx := ctx
+	if connectTimeout > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(ctx, connectTimeout)
+		defer cancel()
+	}
+
+	exporters, err := newExportersFromProviders(connectCtx, provider, opts)
+	if err != nil {
+		if ctx.Err() == nil && connectCtx.Err() != nil {
+			return nil, fmt.Errorf("opentelemetry exporter did not connect within %s: %w", connectTimeout, err)
+		}
+		return nil, setupError(ctx, err)
+	}
+	return exporters, nil
+}
+
+// fullJitter returns a random delay between 0 and delay, such that replicas
+// starting at once do not retry at once; see WithStartupRetryJitter.
+func fullJitter(delay time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SpanLimits bounds the data recorded by every span.
+//
+// A zero limit falls back to the corresponding OTEL_SPAN_*_LIMIT environment
+// variable, e.g. OTEL_SPAN_ATTRIBUTE_COUNT_LIMIT for AttributeCount, or else
+// to the default of the OpenTelemetry SDK. A negative limit means there is no
+// limit.
+type SpanLimits struct {
+	AttributeCount       int
+	AttributeValueLength int
+	EventCount           int
+	LinkCount            int
+}
+
+// resolve returns the SDK span limits, with the limits that are not set read
+// from the environment.
+func (l SpanLimits) resolve() trace.SpanLimits {
+	// NewSpanLimits reads the OTEL_SPAN_*_LIMIT environment variables.
+	limits := trace.NewSpanLimits()
+	if l.AttributeCount != 0 {
+		limits.AttributeCountLimit = l.AttributeCount
+	}
+	if l.AttributeValueLength != 0 {
+		limits.AttributeValueLengthLimit = l.AttributeValueLength
+	}
+	if l.EventCount != 0 {
+		limits.EventCountLimit = l.EventCount
+	}
+	if l.LinkCount != 0 {
+		limits.LinkCountLimit = l.LinkCount
+	}
+	return limits
+}
+
+// configuredSpanName is the name of the span emitted by
+// WithConfiguredSpan.
+const configuredSpanName = "otel.configured"
+
+// configuredSpanKey marks the context of the span emitted by
+// WithConfiguredSpan.
+type configuredSpanKey struct{}
+
+// configuredSpanSampler always samples the span emitted by
+// WithConfiguredSpan and otherwise delegates to the configured sampler.
+type configuredSpanSampler struct {
+	trace.Sampler
+}
+
+func (s configuredSpanSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	if p.ParentContext != nil && p.ParentContext.Value(configuredSpanKey{}) != nil {
+		return trace.AlwaysSample().ShouldSample(p)
+	}
+	return s.Sampler.ShouldSample(p)
+}
@@ -0,0 +1,40 @@
+package cobraotel
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// TraceIDFromContext returns the hex-encoded ID of the trace active in the
+// provided context or an empty string if there is none.
+func TraceIDFromContext(ctx context.Context) string {
+	sc := oteltrace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// SpanIDFromContext returns the hex-encoded ID of the span active in the
+// provided context or an empty string if there is none.
+func SpanIDFromContext(ctx context.Context) string {
+	sc := oteltrace.SpanContextFromContext(ctx)
+	if !sc.HasSpanID() {
+		return ""
+	}
+	return sc.SpanID().String()
+}
+
+// WithTraceID returns a logger that includes the IDs of the trace and span
+// active in the provided context so that logs can be correlated with traces.
+//
+// The logger is returned unchanged if there is no active span.
+func WithTraceID(ctx context.Context, logger logr.Logger) logr.Logger {
+	sc := oteltrace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return logger
+	}
+	return logger.WithValues("traceID", sc.TraceID().String(), "spanID", sc.SpanID().String())
+}
@@ -0,0 +1,47 @@
+package cobraotel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestCorrelation(t *testing.T) {
+	_, span := trace.NewTracerProvider().Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+	sc := span.SpanContext()
+
+	for _, tt := range []struct {
+		name            string
+		ctx             context.Context
+		expectedTraceID string
+		expectedSpanID  string
+	}{
+		{"no span", context.Background(), "", ""},
+		{"invalid span", oteltrace.ContextWithSpanContext(context.Background(), oteltrace.SpanContext{}), "", ""},
+		{"active span", oteltrace.ContextWithSpan(context.Background(), span), sc.TraceID().String(), sc.SpanID().String()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TraceIDFromContext(tt.ctx); got != tt.expectedTraceID {
+				t.Fatalf("expected trace ID %q, got %q", tt.expectedTraceID, got)
+			}
+			if got := SpanIDFromContext(tt.ctx); got != tt.expectedSpanID {
+				t.Fatalf("expected span ID %q, got %q", tt.expectedSpanID, got)
+			}
+
+			var logged string
+			logger := funcr.New(func(prefix, args string) { logged = args }, funcr.Options{})
+			WithTraceID(tt.ctx, logger).Info("message")
+			expected := `"level"=0 "msg"="message"`
+			if tt.expectedTraceID != "" {
+				expected += ` "traceID"="` + tt.expectedTraceID + `" "spanID"="` + tt.expectedSpanID + `"`
+			}
+			if logged != expected {
+				t.Fatalf("expected log %s, got %s", expected, logged)
+			}
+		})
+	}
+}
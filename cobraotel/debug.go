@@ -0,0 +1,68 @@
+package cobraotel
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// debugVerbosity is the verbosity of the logger enabled by "$PREFIX-debug",
+// which includes the debug messages of the OpenTelemetry SDK.
+const debugVerbosity = 8
+
+var (
+	// debugOutput is where the diagnostics enabled by "$PREFIX-debug" are
+	// written.
+	debugOutput   io.Writer = os.Stderr
+	debugOutputMu sync.Mutex
+)
+
+// newDebugLogger returns the logger installed for OpenTelemetry by
+// "$PREFIX-debug".
+func newDebugLogger() logr.Logger {
+	return funcr.New(func(prefix, args string) {
+		debugOutputMu.Lock()
+		defer debugOutputMu.Unlock()
+		fmt.Fprintln(debugOutput, "opentelemetry:", args)
+	}, funcr.Options{Verbosity: debugVerbosity})
+}
+
+// exporterTypes returns the types of the exporters wrapped by the exporters
+// of this package, for diagnostics.
+func exporterTypes(exporters ...trace.SpanExporter) []string {
+	var types []string
+	for _, exporter := range exporters {
+		switch e := exporter.(type) {
+		case *labeledExporter:
+			types = append(types, exporterTypes(e.SpanExporter)...)
+		case *statsExporter:
+			types = append(types, exporterTypes(e.SpanExporter)...)
+		case *backpressureExporter:
+			types = append(types, exporterTypes(e.SpanExporter)...)
+		case *errorHandlerExporter:
+			types = append(types, exporterTypes(e.SpanExporter)...)
+		case *failoverExporter:
+			types = append(types, exporterTypes(e.exporters...)...)
+		default:
+			types = append(types, fmt.Sprintf("%T", e))
+		}
+	}
+	return types
+}
+
+// redactedHeaders returns the keys of the headers, whose values may be
+// secrets, sorted.
+func redactedHeaders(headers map[string]string) []string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package cobraotel
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	var buf bytes.Buffer
+	previous := debugOutput
+	debugOutput = &buf
+	t.Cleanup(func() { debugOutput = previous })
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider="+provider, "--otel-headers=api-key=secret")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no diagnostics without --otel-debug, got %q", buf.String())
+	}
+
+	cmd = newTestCommand(t, b, "--otel-provider="+provider, "--otel-headers=api-key=secret", "--otel-debug")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+
+	debugOutputMu.Lock()
+	output := buf.String()
+	debugOutputMu.Unlock()
+
+	for _, expected := range []string{
+		// Logged by the OpenTelemetry SDK through the installed logger.
+		"TracerProvider created",
+		"resolved opentelemetry configuration",
+		"*tracetest.InMemoryExporter",
+		"api-key",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected diagnostics to contain %q, got %q", expected, output)
+		}
+	}
+	if strings.Contains(output, "secret") {
+		t.Fatalf("expected header values to be redacted, got %q", output)
+	}
+}
@@ -0,0 +1,81 @@
+package cobraotel
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jzelinskie/cobrautil/v2"
+	"github.com/jzelinskie/stringz"
+	"github.com/spf13/cobra"
+)
+
+// DescribeConfig returns the effective tracing configuration resolved from
+// the flags of the provided command, e.g. to print it from a
+// "trace config" subcommand, without installing a tracer provider.
+//
+// The returned settings are keyed by name, such as "provider", "endpoint",
+// "endpointSource", "propagators", "sampler", "headers" and "tls". Secrets
+// are redacted: only the number of headers is reported and credentials are
+// removed from endpoints. If the flags are invalid, only the "error" key is
+// set.
+func (b *Builder) DescribeConfig(cmd *cobra.Command) map[string]string {
+	cfg, err := b.configFromFlags(cmd, cmd.Flags().Args())
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+
+	provider := stringz.DefaultEmpty(cfg.Provider, defaultProvider)
+	endpoint, endpointSource := b.describeEndpoint(cmd, cfg.Endpoint)
+	serviceName := stringz.DefaultEmpty(cfg.ServiceName, b.serviceName)
+	sampler := cfg.Sampler
+	if sampler == nil {
+		sampler = newSampler(samplerConfig{ratio: defaultSampleRatio, now: b.now})
+	}
+
+	tlsMode := "tls"
+	switch {
+	case cfg.Insecure:
+		tlsMode = "insecure"
+	case cfg.TLSConfig != nil && cfg.TLSConfig.InsecureSkipVerify:
+		tlsMode = "tls (certificate verification disabled)"
+	case cfg.TLSConfig != nil && cfg.TLSConfig.ServerName != "":
+		tlsMode = "tls (server name " + cfg.TLSConfig.ServerName + ")"
+	}
+
+	return map[string]string{
+		"provider":       provider,
+		"endpoint":       redactEndpoints(endpoint),
+		"endpointSource": endpointSource,
+		"service":        serviceName,
+		"propagators":    strings.Join(cfg.Propagators, ","),
+		"sampler":        sampler.Description(),
+		"processor":      stringz.DefaultEmpty(cfg.Processor, defaultProcessor),
+		"headers":        strconv.Itoa(len(cfg.Headers)),
+		"tls":            tlsMode,
+		"connectTimeout": cfg.ConnectTimeout.String(),
+		"debug":          strconv.FormatBool(cfg.Debug),
+	}
+}
+
+// describeEndpoint returns the endpoint used by the exporters and where it
+// was configured: "flag", "file", the environment variable it was read from,
+// or "default" when the exporters use their default endpoint.
+func (b *Builder) describeEndpoint(cmd *cobra.Command, endpoint string) (string, string) {
+	if endpoint != "" {
+		// The endpoint was already validated by configFromFlags.
+		endpoint, _ = normalizeEndpoints(endpoint)
+		if flagOrDefault(cmd, b.prefix("endpoint"), "", cobrautil.MustGetString) != "" {
+			return endpoint, "flag"
+		}
+		return endpoint, "file"
+	}
+
+	// The traces-specific variable takes precedence in the OTLP exporters.
+	for _, envVar := range []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"} {
+		if value := os.Getenv(envVar); value != "" {
+			return value, envVar
+		}
+	}
+	return "", "default"
+}
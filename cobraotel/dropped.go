@@ -0,0 +1,112 @@
+package cobraotel
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/stdr"
+)
+
+// defaultOtelLogger returns a logger equivalent to the one used by
+// OpenTelemetry when none is configured.
+func defaultOtelLogger() logr.Logger {
+	return stdr.New(log.New(os.Stderr, "", log.LstdFlags|log.Lshortfile))
+}
+
+// droppedSpanLogger returns the logger installed for OpenTelemetry when
+// WithDroppedSpanCallback is used.
+//
+// The batch span processor does not expose the spans it drops when its queue
+// is full, but it logs a running total at debug level before every export.
+// The returned logger intercepts these messages to invoke the callback,
+// forwarding every message to the provided logger, or the configured logger
+// if none is provided.
+func (b *Builder) droppedSpanLogger(base logr.Logger) logr.Logger {
+	var noLogger logr.Logger
+	if base == noLogger {
+		base = b.logger
+	}
+	if base == noLogger {
+		base = defaultOtelLogger()
+	}
+	return logr.New(&droppedSpanSink{
+		LogSink: base.GetSink(),
+		state:   &droppedSpanState{callback: b.droppedSpanCallback},
+	})
+}
+
+// droppedSpanState tracks the spans reported as dropped across the sinks
+// derived from a droppedSpanSink.
+type droppedSpanState struct {
+	mu       sync.Mutex
+	reported uint32
+	callback func(count int)
+}
+
+func (s *droppedSpanState) observe(total uint32) {
+	s.mu.Lock()
+	if total <= s.reported {
+		s.mu.Unlock()
+		return
+	}
+	count := total - s.reported
+	s.reported = total
+	s.mu.Unlock()
+
+	s.callback(int(count))
+}
+
+// droppedSpanSink is a logr.LogSink reporting the "total_dropped" values
+// logged by the batch span processor to a droppedSpanState.
+type droppedSpanSink struct {
+	logr.LogSink
+	state *droppedSpanState
+}
+
+func (s *droppedSpanSink) Init(info logr.RuntimeInfo) {
+	if s.LogSink != nil {
+		s.LogSink.Init(info)
+	}
+}
+
+// Enabled always returns true in order to observe debug messages, which are
+// only forwarded if they are enabled for the wrapped sink.
+func (s *droppedSpanSink) Enabled(level int) bool { return true }
+
+func (s *droppedSpanSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	if msg == "exporting spans" {
+		for i := 0; i+1 < len(keysAndValues); i += 2 {
+			if keysAndValues[i] == "total_dropped" {
+				if total, ok := keysAndValues[i+1].(uint32); ok {
+					s.state.observe(total)
+				}
+			}
+		}
+	}
+
+	if s.LogSink != nil && s.LogSink.Enabled(level) {
+		s.LogSink.Info(level, msg, keysAndValues...)
+	}
+}
+
+func (s *droppedSpanSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	if s.LogSink != nil {
+		s.LogSink.Error(err, msg, keysAndValues...)
+	}
+}
+
+func (s *droppedSpanSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	if s.LogSink == nil {
+		return s
+	}
+	return &droppedSpanSink{LogSink: s.LogSink.WithValues(keysAndValues...), state: s.state}
+}
+
+func (s *droppedSpanSink) WithName(name string) logr.LogSink {
+	if s.LogSink == nil {
+		return s
+	}
+	return &droppedSpanSink{LogSink: s.LogSink.WithName(name), state: s.state}
+}
@@ -0,0 +1,68 @@
+package cobraotel
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// blockingExporter is a SpanExporter that blocks every export until release
+// is closed and counts the exported spans.
+type blockingExporter struct {
+	*tracetest.InMemoryExporter
+	release  chan struct{}
+	exported atomic.Int64
+}
+
+func (e *blockingExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	<-e.release
+	e.exported.Add(int64(len(spans)))
+	return e.InMemoryExporter.ExportSpans(ctx, spans)
+}
+
+func TestWithDroppedSpanCallback(t *testing.T) {
+	exporter := &blockingExporter{InMemoryExporter: tracetest.NewInMemoryExporter(), release: make(chan struct{})}
+	registerTestProvider(t, "fake-dropped", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		return exporter, nil
+	})
+	t.Setenv("OTEL_BSP_MAX_QUEUE_SIZE", "1")
+	t.Setenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "1")
+
+	var mu sync.Mutex
+	var dropped int
+	b := New("test", WithDroppedSpanCallback(func(count int) {
+		mu.Lock()
+		defer mu.Unlock()
+		dropped += count
+	}))
+	cmd := newTestCommand(t, b, "--otel-provider=fake-dropped", "--otel-sample-ratio=1")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_, span := otel.Tracer("test").Start(context.Background(), "span")
+		span.End()
+	}
+	close(exporter.release)
+	if err := b.tracerProvider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("failed to flush: %s", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	if err := b.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shutdown: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	exported := int(exporter.exported.Load())
+	if dropped == 0 || dropped+exported != 11 {
+		t.Fatalf("expected every span to be either exported or reported dropped, got %d exported and %d dropped", exported, dropped)
+	}
+}
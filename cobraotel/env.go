@@ -0,0 +1,256 @@
+package cobraotel
+
+import (
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jzelinskie/cobrautil/v2"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// EnvFromFlags returns the OTEL_* environment variable assignments, in the
+// form "KEY=value", equivalent to the tracing configuration resolved from
+// the flags of cmd.
+//
+// Passing these to a subprocess using the OpenTelemetry SDK configures it
+// to export traces like the current process. The flags are mapped as follows:
+//
+//	Flag                                   Environment variable
+//	-------------------------------------  -----------------------------------------------------
+//	"$PREFIX-provider"                     OTEL_TRACES_EXPORTER ("otlp" or "none") and
+//	                                       OTEL_EXPORTER_OTLP_TRACES_PROTOCOL ("grpc" or
+//	                                       "http/protobuf", inferred from the endpoint for
+//	                                       the "auto" provider and from
+//	                                       OTEL_EXPORTER_OTLP_PROTOCOL for "otlp")
+//	"$PREFIX-endpoint",                    OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, as a URL with an
+//	"$PREFIX-endpoint-file" and            "http" scheme when insecure and "https" otherwise
+//	"$PREFIX-otlp-traces-path"
+//	"$PREFIX-insecure"                     OTEL_EXPORTER_OTLP_TRACES_INSECURE
+//	"$PREFIX-headers" and                  OTEL_EXPORTER_OTLP_TRACES_HEADERS
+//	"$PREFIX-headers-file"
+//	"$PREFIX-service-name"                 OTEL_SERVICE_NAME, when set
+//	"$PREFIX-sampler",                     OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG
+//	"$PREFIX-sample-ratio" and
+//	"$PREFIX-sampling-ignore-parent"
+//	"$PREFIX-trace-propagator"             OTEL_PROPAGATORS
+//
+// The options of the Builder are applied like they are by RunE, e.g.
+// WithHeadersFromEnv, WithDefaultInsecure, WithInsecureLocalhost and the
+// service name options, reading the positional arguments of cmd for
+// WithServiceNameFromArg like DescribeConfig. A sampler provided to WithSampler is only mapped if
+// it is one of the SDK samplers that can be named by OTEL_TRACES_SAMPLER.
+//
+// When none of the sampler flags is set, OTEL_TRACES_SAMPLER and
+// OTEL_TRACES_SAMPLER_ARG are passed through if OTEL_TRACES_SAMPLER is set,
+// since they take precedence over the default sampler.
+//
+// Only the first of multiple endpoints is used and, of multiple providers
+// separated by "+", only the first OTLP provider along with its endpoints.
+// Flags without an equivalent environment variable, such as
+// "$PREFIX-sampling-rules", are not mapped; neither are providers registered
+// with RegisterProvider, nor values that cannot be resolved. If a flag has
+// another type than the one registered by RegisterFlags, nil is returned.
+func (b *Builder) EnvFromFlags(cmd *cobra.Command) []string {
+	if err := b.checkFlagTypes(cmd); err != nil {
+		return nil
+	}
+
+	var env []string
+	set := func(key, value string) { env = append(env, key+"="+value) }
+
+	// The endpoint cannot be resolved if the endpoint file is missing.
+	endpoint, _ := b.endpointFromFlags(cmd)
+	provider, endpoint := envProvider(b.providerFromFlags(cmd), endpoint)
+	switch provider {
+	case "none":
+		set("OTEL_TRACES_EXPORTER", "none")
+	case "otlphttp":
+		set("OTEL_TRACES_EXPORTER", "otlp")
+		set("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "http/protobuf")
+	case "otlpgrpc":
+		set("OTEL_TRACES_EXPORTER", "otlp")
+		set("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "grpc")
+	}
+
+	if provider == "otlphttp" || provider == "otlpgrpc" {
+		insecure := b.insecureFromFlags(cmd, endpoint)
+		if endpoint := b.endpointURL(cmd, endpoint, provider, insecure); endpoint != "" {
+			set("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", endpoint)
+		}
+		set("OTEL_EXPORTER_OTLP_TRACES_INSECURE", strconv.FormatBool(insecure))
+
+		// The headers cannot be resolved if the headers file is missing.
+		if headers, err := b.headersFromFlags(cmd); err == nil && len(headers) > 0 {
+			set("OTEL_EXPORTER_OTLP_TRACES_HEADERS", formatHeaders(headers))
+		}
+	}
+
+	if serviceName, ok := b.resolveServiceName(cmd, cmd.Flags().Args()); ok {
+		set("OTEL_SERVICE_NAME", serviceName)
+	}
+
+	if sampler, arg := b.envSampler(cmd); sampler != "" {
+		set("OTEL_TRACES_SAMPLER", sampler)
+		if arg != "" {
+			set("OTEL_TRACES_SAMPLER_ARG", arg)
+		}
+	}
+
+	var propagators []string
+	seen := make(map[string]bool)
+	for _, p := range b.PropagatorNames(cmd) {
+		names := []string{"tracecontext", "baggage"}
+		switch p {
+		case "b3":
+			names = []string{"b3"}
+		case "ottrace":
+			names = []string{"ottrace"}
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				propagators = append(propagators, name)
+			}
+		}
+	}
+	set("OTEL_PROPAGATORS", strings.Join(propagators, ","))
+
+	return env
+}
+
+// envSampler returns the values of OTEL_TRACES_SAMPLER and
+// OTEL_TRACES_SAMPLER_ARG equivalent to the configured sampler, or empty
+// strings if it cannot be mapped.
+func (b *Builder) envSampler(cmd *cobra.Command) (string, string) {
+	if b.sampler != nil {
+		return sdkSamplerName(b.sampler)
+	}
+
+	ratio := strconv.FormatFloat(flagOrDefault(cmd, b.prefix("sample-ratio"), defaultSampleRatio, cobrautil.MustGetFloat64), 'g', -1, 64)
+	switch sampler := strings.ToLower(strings.TrimSpace(flagOrDefault(cmd, b.prefix("sampler"), "", cobrautil.MustGetString))); sampler {
+	case "":
+		// OTEL_TRACES_SAMPLER is passed through when it takes precedence
+		// over the sampler flags, like it does for this process.
+		if envName := b.samplerNameFromEnv(cmd); envName != "" {
+			return envName, os.Getenv("OTEL_TRACES_SAMPLER_ARG")
+		}
+		if flagOrDefault(cmd, b.prefix("sampling-ignore-parent"), false, cobrautil.MustGetBool) {
+			return "traceidratio", ratio
+		}
+		return "parentbased_traceidratio", ratio
+	case "traceidratio", "parentbased_traceidratio":
+		return sampler, ratio
+	case "always_on", "always_off", "parentbased_always_on", "parentbased_always_off":
+		return sampler, ""
+	}
+	return "", ""
+}
+
+// sdkSamplerName returns the name, and ratio argument, of the sampler if it
+// is equivalent to one of the named SDK samplers, or empty strings otherwise.
+func sdkSamplerName(sampler trace.Sampler) (string, string) {
+	description := sampler.Description()
+	for _, name := range []string{"always_on", "always_off", "parentbased_always_on", "parentbased_always_off"} {
+		if named, _ := namedSampler(name, 0); named.Description() == description {
+			return name, ""
+		}
+	}
+
+	// The ratio is the first number of the description of both
+	// TraceIDRatioBased and ParentBased(TraceIDRatioBased).
+	_, rest, _ := strings.Cut(description, "TraceIDRatioBased{")
+	arg, _, _ := strings.Cut(rest, "}")
+	ratio, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return "", ""
+	}
+	for _, name := range []string{"traceidratio", "parentbased_traceidratio"} {
+		if named, _ := namedSampler(name, ratio); named.Description() == description {
+			return name, arg
+		}
+	}
+	return "", ""
+}
+
+// envProvider returns the provider, and its endpoints, that are mapped to
+// environment variables. Of multiple providers separated by "+", the first
+// OTLP provider is mapped, since the environment variables only configure a
+// single OTLP exporter. The "auto" provider is inferred from its endpoints
+// and the "otlp" provider from the OTLP protocol environment variables.
+func envProvider(provider, endpoint string) (string, string) {
+	providers := strings.Split(provider, "+")
+	endpoints := strings.Split(endpoint, "+")
+	for i, p := range providers {
+		group := endpoint
+		if len(endpoints) == len(providers) {
+			group = endpoints[i]
+		}
+		// A provider that cannot be resolved is not mapped.
+		switch p = strings.TrimSpace(p); p {
+		case "auto":
+			p, _ = inferProviderFromEndpoints(group)
+		case "otlp":
+			p, _ = providerFromProtocol()
+		}
+		switch p {
+		case "otlphttp", "otlpgrpc":
+			return p, group
+		}
+	}
+	return provider, endpoint
+}
+
+// endpointURL returns the first of the endpoints as a URL, or an empty string
+// if none was configured or it cannot be resolved.
+func (b *Builder) endpointURL(cmd *cobra.Command, raw, provider string, insecure bool) string {
+	normalized, err := normalizeEndpoints(raw)
+	if err != nil {
+		return ""
+	}
+	endpoints := splitEndpoints(normalized)
+	if len(endpoints) == 0 {
+		return ""
+	}
+	endpoint := endpoints[0]
+	if grpcTargetScheme(endpoint) != "" {
+		return ""
+	}
+	host, path := splitEndpointPath(endpoint)
+
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	u := url.URL{Scheme: scheme, Host: host}
+	if provider == "otlphttp" {
+		u.Path = "/v1/traces"
+		if tracesPath := flagOrDefault(cmd, b.prefix("otlp-traces-path"), "", cobrautil.MustGetString); tracesPath != "" {
+			u.Path = tracesPath
+		} else if path != "" {
+			u.Path = path
+		}
+	}
+	return u.String()
+}
+
+// formatHeaders formats headers in the format of OTEL_EXPORTER_OTLP_HEADERS,
+// sorted by key; it is the inverse of parseHeaders.
+func formatHeaders(headers map[string]string) string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		// Spaces are escaped as "%20" rather than "+", which is only decoded
+		// by query unescaping.
+		pairs = append(pairs, k+"="+strings.ReplaceAll(url.QueryEscape(headers[k]), "+", "%20"))
+	}
+	return strings.Join(pairs, ",")
+}
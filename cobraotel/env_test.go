@@ -0,0 +1,360 @@
+package cobraotel
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jzelinskie/stringz"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestEnvFromFlags(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		opts     []Option
+		args     []string
+		env      map[string]string
+		expected []string
+	}{
+		{
+			name: "defaults",
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "otlphttp",
+			args: []string{
+				"--otel-provider=otlphttp",
+				"--otel-endpoint=collector:4318,backup:4318",
+				"--otel-insecure",
+				"--otel-headers=authorization=Bearer token",
+				"--otel-sample-ratio=0.5",
+				"--otel-sampling-ignore-parent",
+				"--otel-trace-propagator=b3,w3c",
+			},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=http/protobuf",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=http://collector:4318/v1/traces",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=true",
+				"OTEL_EXPORTER_OTLP_TRACES_HEADERS=authorization=Bearer%20token",
+				"OTEL_TRACES_SAMPLER=traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.5",
+				"OTEL_PROPAGATORS=b3,tracecontext,baggage",
+			},
+		},
+		{
+			name: "otlphttp with path",
+			args: []string{
+				"--otel-provider=otlphttp",
+				"--otel-endpoint=https://collector:4318/custom/traces?token=abc",
+			},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=http/protobuf",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=https://collector:4318/custom/traces",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "otlpgrpc",
+			args: []string{
+				"--otel-provider=otlpgrpc",
+				"--otel-endpoint=collector:4317",
+				"--otel-sampler=always_on",
+			},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=grpc",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=https://collector:4317",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_TRACES_SAMPLER=always_on",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "auto",
+			args: []string{
+				"--otel-provider=auto",
+				"--otel-endpoint=grpc://collector:4317",
+			},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=grpc",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=https://collector:4317",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "auto among multiple providers",
+			args: []string{
+				"--otel-provider=fake+auto",
+				"--otel-endpoint=fake:1234+https://collector:4318/v1/traces",
+			},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=http/protobuf",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=https://collector:4318/v1/traces",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "otlp",
+			args: []string{
+				"--otel-provider=otlp",
+				"--otel-endpoint=collector:4318",
+			},
+			env: map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "http/protobuf"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=http/protobuf",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=https://collector:4318/v1/traces",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "otlp with default protocol",
+			args: []string{"--otel-provider=otlp"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=grpc",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "multiple providers",
+			args: []string{
+				"--otel-provider=fake+otlphttp+otlpgrpc",
+				"--otel-endpoint=fake:1234+collector:4318+collector:4317",
+				"--otel-insecure",
+			},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=http/protobuf",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=http://collector:4318/v1/traces",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=true",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "multiple providers sharing endpoints",
+			args: []string{
+				"--otel-provider=fake+otlpgrpc",
+				"--otel-endpoint=collector:4317",
+			},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=grpc",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=https://collector:4317",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "service name",
+			args: []string{"--otel-service-name=worker"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_SERVICE_NAME=worker",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "sampler from env",
+			env:  map[string]string{"OTEL_TRACES_SAMPLER": "always_on"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_TRACES_SAMPLER=always_on",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "sampler arg from env",
+			env:  map[string]string{"OTEL_TRACES_SAMPLER": "traceidratio", "OTEL_TRACES_SAMPLER_ARG": "0.25"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_TRACES_SAMPLER=traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.25",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "sample ratio flag overrides sampler env",
+			args: []string{"--otel-sample-ratio=0.5"},
+			env:  map[string]string{"OTEL_TRACES_SAMPLER": "always_on"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.5",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "default insecure",
+			opts: []Option{WithDefaultInsecure(true)},
+			args: []string{"--otel-provider=otlpgrpc", "--otel-endpoint=collector:4317"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=grpc",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=http://collector:4317",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=true",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "insecure localhost",
+			opts: []Option{WithInsecureLocalhost()},
+			args: []string{"--otel-provider=otlpgrpc", "--otel-endpoint=localhost:4317"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=grpc",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=http://localhost:4317",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=true",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "headers from env",
+			opts: []Option{WithHeadersFromEnv("COBRAOTEL_TEST_HEADERS")},
+			args: []string{"--otel-provider=otlpgrpc", "--otel-endpoint=collector:4317", "--otel-headers=tenant=from-flag"},
+			env:  map[string]string{"COBRAOTEL_TEST_HEADERS": "api-key=from-env,tenant=from-env"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=otlp",
+				"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL=grpc",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT=https://collector:4317",
+				"OTEL_EXPORTER_OTLP_TRACES_INSECURE=false",
+				"OTEL_EXPORTER_OTLP_TRACES_HEADERS=api-key=from-env,tenant=from-flag",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "service name from env fallback",
+			opts: []Option{WithServiceNameFromEnvFallback("COBRAOTEL_TEST_SERVICE")},
+			env:  map[string]string{"COBRAOTEL_TEST_SERVICE": "from-env"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_SERVICE_NAME=from-env",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "service name from arg",
+			opts: []Option{WithServiceNameFromArg(0)},
+			args: []string{"worker"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_SERVICE_NAME=worker",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "service name func",
+			opts: []Option{WithServiceNameFunc(func() string { return "from-func" })},
+			args: []string{"--otel-service-name=worker"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_SERVICE_NAME=from-func",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.01",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "sampler option",
+			opts: []Option{WithSampler(trace.AlwaysSample())},
+			args: []string{"--otel-sample-ratio=0.5"},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_TRACES_SAMPLER=always_on",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "ratio sampler option",
+			opts: []Option{WithSampler(trace.ParentBased(trace.TraceIDRatioBased(0.25)))},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_TRACES_SAMPLER=parentbased_traceidratio",
+				"OTEL_TRACES_SAMPLER_ARG=0.25",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+		{
+			name: "custom sampler option",
+			opts: []Option{WithSampler(trace.ParentBased(trace.AlwaysSample(), trace.WithRemoteParentSampled(trace.NeverSample())))},
+			expected: []string{
+				"OTEL_TRACES_EXPORTER=none",
+				"OTEL_PROPAGATORS=tracecontext,baggage",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			b := New("test", tt.opts...)
+			cmd := newTestCommand(t, b, tt.args...)
+			if got := b.EnvFromFlags(cmd); !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEnvFromFlagsHeadersFile(t *testing.T) {
+	headersFile := filepath.Join(t.TempDir(), "headers")
+	if err := os.WriteFile(headersFile, []byte("api-key=from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider=otlpgrpc", "--otel-headers-file="+headersFile)
+	if got := b.EnvFromFlags(cmd); !stringz.SliceContains(got, "OTEL_EXPORTER_OTLP_TRACES_HEADERS=api-key=from-file") {
+		t.Fatalf("expected the headers file to be mapped, got %q", got)
+	}
+
+	cmd = newTestCommand(t, b, "--otel-provider=otlpgrpc", "--otel-headers-file="+filepath.Join(t.TempDir(), "missing"))
+	for _, assignment := range b.EnvFromFlags(cmd) {
+		if strings.HasPrefix(assignment, "OTEL_EXPORTER_OTLP_TRACES_HEADERS=") {
+			t.Fatalf("expected a missing headers file not to be mapped, got %q", assignment)
+		}
+	}
+}
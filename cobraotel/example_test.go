@@ -0,0 +1,21 @@
+package cobraotel_test
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/jzelinskie/cobrautil/v2/cobraotel"
+)
+
+func ExampleResetGlobalsForTest() {
+	otel := cobraotel.New("myservice")
+
+	cmd := &cobra.Command{
+		Use:     "mycmd",
+		PreRunE: otel.RunE(),
+	}
+	otel.RegisterFlags(cmd.Flags())
+
+	// In a test, undo the global tracer provider and propagator installed by
+	// the command once the test completes, e.g. t.Cleanup(...).
+	defer cobraotel.ResetGlobalsForTest()
+}
@@ -0,0 +1,92 @@
+package cobraotel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// defaultGlobalTracerProvider is the global tracer provider used by
+// OpenTelemetry until one is installed.
+var defaultGlobalTracerProvider = otel.GetTracerProvider()
+
+var (
+	installedMu sync.Mutex
+
+	// installed is the tracer provider most recently installed as the
+	// global tracer provider by any Builder.
+	installed *trace.TracerProvider
+)
+
+// setGlobalTracerProvider installs tp as the global tracer provider and
+// records it as installed by this package.
+func setGlobalTracerProvider(tp *trace.TracerProvider) {
+	installedMu.Lock()
+	defer installedMu.Unlock()
+	installed = tp
+	otel.SetTracerProvider(tp)
+}
+
+// isExternalTracerProvider returns whether tp was installed as the global
+// tracer provider by something other than a Builder of this package, such
+// that one Builder does not mistake a tracer provider installed by another,
+// e.g. created with Child, for one installed by another library.
+func isExternalTracerProvider(tp oteltrace.TracerProvider) bool {
+	switch tp {
+	case defaultGlobalTracerProvider, oteltrace.NewNoopTracerProvider():
+		return false
+	}
+
+	installedMu.Lock()
+	defer installedMu.Unlock()
+	return installed == nil || tp != oteltrace.TracerProvider(installed)
+}
+
+// installNoop installs a no-op global tracer provider and an empty global
+// text map propagator; see WithInstallNoopOnNone.
+func (b *Builder) installNoop() {
+	if existing := otel.GetTracerProvider(); b.respectExistingGlobal && isExternalTracerProvider(existing) {
+		b.logger.V(b.preRunLevel).Info("keeping existing global tracer provider", "existing", fmt.Sprintf("%T", existing))
+	} else {
+		otel.SetTracerProvider(oteltrace.NewNoopTracerProvider())
+	}
+	if !b.noPropagate {
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}
+}
+
+// uninstallTracerProvider shuts down the tracer provider configured by a
+// previous call to Configure, if any, replacing it with a no-op global tracer
+// provider if it is still installed.
+func (b *Builder) uninstallTracerProvider(ctx context.Context) {
+	previous, previousShutdown := b.replaceTracerProvider(nil, nil, nil, nil)
+	if previous == nil {
+		return
+	}
+	if otel.GetTracerProvider() == oteltrace.TracerProvider(previous) {
+		otel.SetTracerProvider(oteltrace.NewNoopTracerProvider())
+	}
+	b.shutdownReplaced(ctx, previous, previousShutdown)
+}
+
+// ResetGlobalsForTest restores the global tracer provider and text map
+// propagator installed by RunE() to no-op defaults, and the OpenTelemetry
+// logger to its default.
+//
+// This is only intended for use in tests, e.g. with t.Cleanup or in TestMain,
+// to prevent global state from leaking between tests. It is not safe to call
+// concurrently with code that uses or configures the globals.
+func ResetGlobalsForTest() {
+	installedMu.Lock()
+	installed = nil
+	installedMu.Unlock()
+
+	otel.SetTracerProvider(oteltrace.NewNoopTracerProvider())
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	otel.SetLogger(defaultOtelLogger())
+}
@@ -0,0 +1,22 @@
+package cobraotel
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ExtractMiddleware extracts the trace context propagated in the headers of
+// incoming requests into their context, using the global text map propagator
+// configured by RunE().
+//
+// No span is started: this only allows spans started by the handler to join
+// the trace of the caller without depending on the instrumentation of
+// net/http provided by otelhttp.
+func ExtractMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,32 @@
+package cobraotel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestExtractMiddleware(t *testing.T) {
+	t.Cleanup(ResetGlobalsForTest)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var got oteltrace.SpanContext
+	handler := ExtractMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = oteltrace.SpanContextFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || got.SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("expected propagated span context, got trace %s and span %s", got.TraceID(), got.SpanID())
+	}
+	if !got.IsRemote() || !got.IsSampled() {
+		t.Fatal("expected a remote, sampled span context")
+	}
+}
@@ -0,0 +1,130 @@
+package cobraotel
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// estimatedSpanBytes is the assumed size of a span encoded by the OTLP
+// exporters, used to derive batch sizes from payload sizes.
+//
+// Spans with few attributes and events are typically a few hundred bytes,
+// so this leaves headroom for larger ones.
+const estimatedSpanBytes = 1024
+
+// exportBatchSize returns the maximum export batch size keeping payloads
+// under maxPayloadBytes, or batchSize if no payload limit is set.
+//
+// This is a heuristic: the size of every span is assumed to be
+// estimatedSpanBytes, so the batch size is lowered to
+// maxPayloadBytes/estimatedSpanBytes (at least 1) if it was larger. When
+// batchSize is 0, the SDK default is used as a starting point. Spans much
+// larger than estimatedSpanBytes can still produce larger payloads.
+func exportBatchSize(batchSize, maxPayloadBytes int) int {
+	if maxPayloadBytes <= 0 {
+		return batchSize
+	}
+	if batchSize <= 0 {
+		batchSize = trace.DefaultMaxExportBatchSize
+	}
+
+	limit := maxPayloadBytes / estimatedSpanBytes
+	if limit < 1 {
+		limit = 1
+	}
+	if batchSize > limit {
+		return limit
+	}
+	return batchSize
+}
+
+// filterSpanProcessor only forwards ended spans that errored or lasted at
+// least a minimum duration to the wrapped processor.
+//
+// When both filters are enabled, a span matching either one is forwarded.
+//
+// Filtering happens independently for every span as it ends: this is not tail
+// sampling, so a trace may be exported with only some of its spans and the
+// parents of exported spans may be missing.
+type filterSpanProcessor struct {
+	trace.SpanProcessor
+	errorsOnly  bool
+	minDuration time.Duration
+}
+
+func newFilterSpanProcessor(next trace.SpanProcessor, errorsOnly bool, minDuration time.Duration) trace.SpanProcessor {
+	return filterSpanProcessor{SpanProcessor: next, errorsOnly: errorsOnly, minDuration: minDuration}
+}
+
+func (p filterSpanProcessor) OnEnd(s trace.ReadOnlySpan) {
+	if p.errorsOnly && s.Status().Code == codes.Error {
+		p.SpanProcessor.OnEnd(s)
+		return
+	}
+	if p.minDuration > 0 && s.EndTime().Sub(s.StartTime()) >= p.minDuration {
+		p.SpanProcessor.OnEnd(s)
+	}
+}
+
+// keepSlowSpanProcessor forwards the root spans that were not sampled but
+// lasted at least a threshold to the wrapped processor as if they were
+// sampled; see WithKeepSlowSpans.
+//
+// Spans only reach span processors when they are recorded, so the sampler is
+// wrapped in a recordRootSampler recording the root spans it drops.
+type keepSlowSpanProcessor struct {
+	trace.SpanProcessor
+	threshold time.Duration
+}
+
+func (p keepSlowSpanProcessor) OnEnd(s trace.ReadOnlySpan) {
+	if s.SpanContext().IsSampled() {
+		p.SpanProcessor.OnEnd(s)
+		return
+	}
+	if !s.Parent().IsValid() && s.EndTime().Sub(s.StartTime()) >= p.threshold {
+		p.SpanProcessor.OnEnd(sampledSpan{s})
+	}
+}
+
+// sampledSpan overrides the span context of a span to mark it sampled, such
+// that exporting span processors export it.
+type sampledSpan struct {
+	trace.ReadOnlySpan
+}
+
+func (s sampledSpan) SpanContext() oteltrace.SpanContext {
+	sc := s.ReadOnlySpan.SpanContext()
+	return sc.WithTraceFlags(sc.TraceFlags().WithSampled(true))
+}
+
+// constantAttributesSpanProcessor sets constant attributes on every span as
+// it starts.
+//
+// Attributes already provided when starting the span are not overridden and
+// attributes set on the span afterwards take precedence.
+type constantAttributesSpanProcessor struct {
+	attrs []attribute.KeyValue
+}
+
+func newConstantAttributesSpanProcessor(attrs []attribute.KeyValue) trace.SpanProcessor {
+	return constantAttributesSpanProcessor{attrs: attrs}
+}
+
+func (p constantAttributesSpanProcessor) OnStart(_ context.Context, s trace.ReadWriteSpan) {
+	existing := attribute.NewSet(s.Attributes()...)
+	for _, attr := range p.attrs {
+		if !existing.HasValue(attr.Key) {
+			s.SetAttributes(attr)
+		}
+	}
+}
+
+func (constantAttributesSpanProcessor) OnEnd(trace.ReadOnlySpan)         {}
+func (constantAttributesSpanProcessor) Shutdown(context.Context) error   { return nil }
+func (constantAttributesSpanProcessor) ForceFlush(context.Context) error { return nil }
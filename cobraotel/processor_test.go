@@ -0,0 +1,215 @@
+package cobraotel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestFilterSpanProcessor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := trace.NewTracerProvider(trace.WithSpanProcessor(newFilterSpanProcessor(recorder, true, time.Second)))
+	tracer := tp.Tracer("test")
+
+	start := time.Unix(0, 0)
+	endSpan := func(name string, d time.Duration, failed bool) {
+		_, span := tracer.Start(context.Background(), name, oteltrace.WithTimestamp(start))
+		if failed {
+			span.SetStatus(codes.Error, "failed")
+		}
+		span.End(oteltrace.WithTimestamp(start.Add(d)))
+	}
+
+	endSpan("fast", time.Millisecond, false)
+	endSpan("fast-error", time.Millisecond, true)
+	endSpan("slow", 2*time.Second, false)
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+	}
+	if len(names) != 2 || names[0] != "fast-error" || names[1] != "slow" {
+		t.Fatalf("expected only fast-error and slow to be exported, got %v", names)
+	}
+}
+
+func TestConstantAttributesSpanProcessor(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := trace.NewTracerProvider(
+		trace.WithSpanProcessor(newConstantAttributesSpanProcessor([]attribute.KeyValue{
+			attribute.String("cluster", "us-east-1"),
+			attribute.Bool("feature.enabled", true),
+		})),
+		trace.WithSpanProcessor(recorder),
+	)
+	tracer := tp.Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "default")
+	span.End()
+	_, span = tracer.Start(context.Background(), "override", oteltrace.WithAttributes(attribute.String("cluster", "eu-west-1")))
+	span.SetAttributes(attribute.Bool("feature.enabled", false))
+	span.End()
+
+	expected := map[string]struct {
+		cluster string
+		enabled bool
+	}{
+		"default":  {"us-east-1", true},
+		"override": {"eu-west-1", false},
+	}
+	for _, s := range recorder.Ended() {
+		attrs := attribute.NewSet(s.Attributes()...)
+		if value, _ := attrs.Value("cluster"); value.AsString() != expected[s.Name()].cluster {
+			t.Fatalf("expected cluster %q on span %q, got %q", expected[s.Name()].cluster, s.Name(), value.AsString())
+		}
+		if value, _ := attrs.Value("feature.enabled"); value.AsBool() != expected[s.Name()].enabled {
+			t.Fatalf("expected feature.enabled %t on span %q, got %t", expected[s.Name()].enabled, s.Name(), value.AsBool())
+		}
+	}
+}
+
+func TestExportBatchSize(t *testing.T) {
+	for _, tt := range []struct {
+		batchSize       int
+		maxPayloadBytes int
+		expected        int
+	}{
+		{0, 0, 0},
+		{100, 0, 100},
+		{0, 1 << 20, trace.DefaultMaxExportBatchSize},
+		{0, 64 << 10, 64},
+		{32, 64 << 10, 32},
+		{100, 64 << 10, 64},
+		{100, 100, 1},
+	} {
+		if got := exportBatchSize(tt.batchSize, tt.maxPayloadBytes); got != tt.expected {
+			t.Fatalf("expected batch size %d for %d spans and %d bytes, got %d", tt.expected, tt.batchSize, tt.maxPayloadBytes, got)
+		}
+	}
+}
+
+// recordingProcessor records the order in which span processors run.
+type recordingProcessor struct {
+	name   string
+	events *[]string
+}
+
+func (p recordingProcessor) OnStart(_ context.Context, s trace.ReadWriteSpan) {
+	var hasTeam bool
+	for _, attr := range s.Attributes() {
+		hasTeam = hasTeam || attr.Key == "team"
+	}
+	*p.events = append(*p.events, fmt.Sprintf("%s:start:%t", p.name, hasTeam))
+}
+
+func (p recordingProcessor) OnEnd(trace.ReadOnlySpan) {
+	*p.events = append(*p.events, p.name+":end")
+}
+
+func (recordingProcessor) Shutdown(context.Context) error   { return nil }
+func (recordingProcessor) ForceFlush(context.Context) error { return nil }
+
+// recordingExporter records the exports in the same events as
+// recordingProcessor.
+type recordingExporter struct {
+	*tracetest.InMemoryExporter
+	events *[]string
+}
+
+func (e recordingExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	*e.events = append(*e.events, "export")
+	return e.InMemoryExporter.ExportSpans(ctx, spans)
+}
+
+func TestWithSpanProcessorOrder(t *testing.T) {
+	var events []string
+	registerTestProvider(t, "fake-processor-order", func(context.Context, ExporterOptions) (trace.SpanExporter, error) {
+		return recordingExporter{InMemoryExporter: tracetest.NewInMemoryExporter(), events: &events}, nil
+	})
+
+	for _, tt := range []struct {
+		name     string
+		order    SpanProcessorOrder
+		expected []string
+	}{
+		{"after", SpanProcessorsAfterBuiltin, []string{"first:start:true", "second:start:true", "first:end", "second:end", "export"}},
+		{"before", SpanProcessorsBeforeBuiltin, []string{"first:start:false", "second:start:false", "first:end", "second:end", "export"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			events = nil
+			b := New("test",
+				WithConstantSpanAttributes(attribute.String("team", "tracing")),
+				WithSpanProcessors(recordingProcessor{"first", &events}, recordingProcessor{"second", &events}),
+				WithSpanProcessorOrder(tt.order),
+			)
+			cmd := newTestCommand(t, b, "--otel-provider=fake-processor-order", "--otel-processor=simple", "--otel-sample-ratio=1")
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+
+			_, span := b.Tracer("test").Start(context.Background(), "span")
+			span.End()
+			if !reflect.DeepEqual(events, tt.expected) {
+				t.Fatalf("expected processors to run in order %v, got %v", tt.expected, events)
+			}
+		})
+	}
+}
+
+func TestWithKeepSlowSpans(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	start := time.Unix(0, 0)
+	for _, tt := range []struct {
+		name     string
+		opts     []Option
+		args     []string
+		expected []string
+	}{
+		{"disabled", nil, []string{"--otel-sample-ratio=0"}, nil},
+		{"enabled", []Option{WithKeepSlowSpans(time.Second)}, []string{"--otel-sample-ratio=0"}, []string{"slow", "slow healthz"}},
+		{"ignored", []Option{WithKeepSlowSpans(time.Second)}, []string{"--otel-sample-ratio=0", "--otel-ignore-attributes=http.target=/healthz"}, []string{"slow"}},
+		{"always off", []Option{WithKeepSlowSpans(time.Second)}, []string{"--otel-sampler=always_off"}, nil},
+		{"parent based always off", []Option{WithKeepSlowSpans(time.Second)}, []string{"--otel-sampler=parentbased_always_off"}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			b := New("test", tt.opts...)
+			cmd := newTestCommand(t, b, append([]string{"--otel-provider=" + provider, "--otel-processor=simple"}, tt.args...)...)
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+
+			tracer := b.Tracer("test")
+			_, fast := tracer.Start(context.Background(), "fast", oteltrace.WithTimestamp(start))
+			fast.End(oteltrace.WithTimestamp(start.Add(time.Millisecond)))
+
+			ctx, slow := tracer.Start(context.Background(), "slow", oteltrace.WithTimestamp(start))
+			_, child := tracer.Start(ctx, "slow child", oteltrace.WithTimestamp(start))
+			child.End(oteltrace.WithTimestamp(start.Add(2 * time.Second)))
+			slow.End(oteltrace.WithTimestamp(start.Add(2 * time.Second)))
+
+			_, healthz := tracer.Start(context.Background(), "slow healthz", oteltrace.WithTimestamp(start), oteltrace.WithAttributes(attribute.String("http.target", "/healthz")))
+			healthz.End(oteltrace.WithTimestamp(start.Add(2 * time.Second)))
+
+			var names []string
+			for _, span := range exporter.GetSpans() {
+				if !span.SpanContext.IsSampled() {
+					t.Fatalf("expected exported span %q to be marked sampled", span.Name)
+				}
+				names = append(names, span.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Fatalf("expected exported spans %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
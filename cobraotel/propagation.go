@@ -0,0 +1,176 @@
+package cobraotel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/jzelinskie/cobrautil/v2"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// PropagatorNames returns the canonical names of the trace propagation
+// formats provided by "$PREFIX-trace-propagator", e.g. to display the
+// effective configuration.
+//
+// Names are trimmed and lowercased, duplicates are removed and unknown names
+// are reported as "w3c", the format they fall back to. The default, "w3c",
+// is returned when the flag is unset or empty.
+func (b *Builder) PropagatorNames(cmd *cobra.Command) []string {
+	return normalizePropagators(strings.Split(flagOrDefault(cmd, b.prefix("trace-propagator"), defaultTracePropagator, cobrautil.MustGetString), ","))
+}
+
+// normalizePropagators returns the canonical names of trace propagation
+// formats; see PropagatorNames.
+func normalizePropagators(propagators []string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range propagators {
+		name := strings.ToLower(strings.TrimSpace(p))
+		switch name {
+		case "":
+			continue
+		case "b3", "ottrace", "w3c":
+		default:
+			name = "w3c"
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{defaultTracePropagator}
+	}
+	return names
+}
+
+// setTracePropagators sets the global propagator for the trace propagation
+// formats provided by "$PREFIX-trace-propagator", unless they are overridden
+// by WithExtractPropagators or WithInjectPropagators.
+//
+// With WithLenientExtraction, malformed "traceparent" headers are repaired
+// before trace context is extracted.
+func (b *Builder) setTracePropagators(propagators []string) {
+	if b.extractPropagators == nil && b.injectPropagators == nil && !b.lenientExtraction {
+		setTracePropagators(propagators)
+		return
+	}
+
+	extract, inject := propagators, propagators
+	if b.extractPropagators != nil {
+		extract = b.extractPropagators
+	}
+	if b.injectPropagators != nil {
+		inject = b.injectPropagators
+	}
+
+	extractor := newTracePropagator(extract)
+	if b.lenientExtraction {
+		extractor = lenientPropagator{TextMapPropagator: extractor, logger: b.logger.V(b.preRunLevel)}
+	}
+	otel.SetTextMapPropagator(asymmetricPropagator{
+		extract: extractor,
+		inject:  newTracePropagator(inject),
+	})
+}
+
+// asymmetricPropagator is a TextMapPropagator extracting and injecting trace
+// context with different propagators.
+type asymmetricPropagator struct {
+	extract propagation.TextMapPropagator
+	inject  propagation.TextMapPropagator
+}
+
+var _ propagation.TextMapPropagator = asymmetricPropagator{}
+
+func (p asymmetricPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	p.inject.Inject(ctx, carrier)
+}
+
+func (p asymmetricPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	return p.extract.Extract(ctx, carrier)
+}
+
+// Fields returns the keys set by Inject.
+func (p asymmetricPropagator) Fields() []string {
+	return p.inject.Fields()
+}
+
+const traceparentHeader = "traceparent"
+
+// lenientPropagator repairs malformed "traceparent" values before extracting
+// trace context with the wrapped propagator; see repairTraceparent.
+type lenientPropagator struct {
+	propagation.TextMapPropagator
+	logger logr.Logger
+}
+
+func (p lenientPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if value := carrier.Get(traceparentHeader); value != "" {
+		if repaired, ok := repairTraceparent(value); ok {
+			p.logger.Info("repaired malformed traceparent", "traceparent", value, "repaired", repaired)
+			carrier = traceparentCarrier{TextMapCarrier: carrier, traceparent: repaired}
+		}
+	}
+	return p.TextMapPropagator.Extract(ctx, carrier)
+}
+
+// traceparentCarrier overrides the "traceparent" value of a carrier without
+// modifying it.
+type traceparentCarrier struct {
+	propagation.TextMapCarrier
+	traceparent string
+}
+
+func (c traceparentCarrier) Get(key string) string {
+	if strings.EqualFold(key, traceparentHeader) {
+		return c.traceparent
+	}
+	return c.TextMapCarrier.Get(key)
+}
+
+// repairTraceparent repairs common malformations of a "traceparent" value
+// emitted by non-compliant proxies, returning the repaired value and whether
+// it differs from the original.
+//
+// Surrounding whitespace is trimmed, hex digits are lowercased, the version
+// is replaced with "00" (e.g. the invalid "ff" or a single digit) and
+// trace flags are padded to two digits. Missing trace flags default to "00":
+// a malformed header does not cause the trace to be sampled. Values without
+// a valid trace ID and span ID are not repaired.
+func repairTraceparent(value string) (string, bool) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(value)), "-")
+	if len(parts) != 3 && len(parts) != 4 {
+		return "", false
+	}
+
+	traceID, spanID, flags := parts[1], parts[2], "00"
+	if len(parts) == 4 {
+		flags = parts[3]
+		if len(flags) == 1 {
+			flags = "0" + flags
+		}
+	}
+	if !isHex(traceID, 32) || !isHex(spanID, 16) || !isHex(flags, 2) {
+		return "", false
+	}
+
+	repaired := "00-" + traceID + "-" + spanID + "-" + flags
+	return repaired, repaired != value
+}
+
+// isHex returns whether s consists of n lowercase hex digits.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
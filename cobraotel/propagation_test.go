@@ -0,0 +1,139 @@
+package cobraotel
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestAsymmetricPropagators(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	b := New("test", WithExtractPropagators("b3", "w3c"), WithInjectPropagators("w3c"))
+	cmd := newTestCommand(t, b, "--otel-provider="+provider)
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	propagator := otel.GetTextMapPropagator()
+
+	// b3 is accepted from inbound requests...
+	inbound := propagation.MapCarrier{"b3": "4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1"}
+	ctx := propagator.Extract(context.Background(), inbound)
+	sc := oteltrace.SpanContextFromContext(ctx)
+	if sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected b3 trace context to be extracted, got trace %s", sc.TraceID())
+	}
+
+	// ...but only w3c is emitted.
+	outbound := propagation.MapCarrier{}
+	propagator.Inject(ctx, outbound)
+	if _, ok := outbound["b3"]; ok {
+		t.Fatal("expected b3 not to be injected")
+	}
+	if expected := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; outbound["traceparent"] != expected {
+		t.Fatalf("expected traceparent %q, got %q", expected, outbound["traceparent"])
+	}
+	for _, field := range propagator.Fields() {
+		if field == "b3" {
+			t.Fatalf("expected fields to only contain injected keys, got %v", propagator.Fields())
+		}
+	}
+}
+
+func TestLenientExtraction(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	for _, tt := range []struct {
+		name            string
+		traceparent     string
+		expectedSampled bool
+		expectedRepair  bool
+	}{
+		{"valid", "00-" + traceID + "-" + spanID + "-01", true, false},
+		{"invalid version", "ff-" + traceID + "-" + spanID + "-01", true, true},
+		{"single digit version", "0-" + traceID + "-" + spanID + "-01", true, true},
+		{"missing trace flags", "00-" + traceID + "-" + spanID, false, true},
+		{"single digit trace flags", "00-" + traceID + "-" + spanID + "-1", true, true},
+		{"uppercase", "00-" + strings.ToUpper(traceID) + "-" + strings.ToUpper(spanID) + "-01", true, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, lenient := range []bool{false, true} {
+				var repaired bool
+				logger := funcr.New(func(_, args string) {
+					repaired = repaired || strings.Contains(args, "repaired malformed traceparent")
+				}, funcr.Options{})
+
+				opts := []Option{WithLogger(logger)}
+				if lenient {
+					opts = append(opts, WithLenientExtraction())
+				}
+				b := New("test", opts...)
+				cmd := newTestCommand(t, b, "--otel-provider="+provider)
+				if err := b.RunE()(cmd, nil); err != nil {
+					t.Fatalf("RunE failed: %s", err)
+				}
+
+				carrier := propagation.MapCarrier{"traceparent": tt.traceparent}
+				sc := oteltrace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), carrier))
+
+				if expected := lenient || !tt.expectedRepair; sc.IsValid() != expected {
+					t.Fatalf("expected extracted trace context to be valid: %t (lenient: %t)", expected, lenient)
+				}
+				if !sc.IsValid() {
+					continue
+				}
+				if sc.TraceID().String() != traceID || sc.SpanID().String() != spanID || sc.IsSampled() != tt.expectedSampled {
+					t.Fatalf("unexpected trace context %s-%s (sampled: %t)", sc.TraceID(), sc.SpanID(), sc.IsSampled())
+				}
+				if repaired != (lenient && tt.expectedRepair) {
+					t.Fatalf("expected repair to be logged: %t", lenient && tt.expectedRepair)
+				}
+				if carrier["traceparent"] != tt.traceparent {
+					t.Fatal("expected the carrier not to be modified")
+				}
+			}
+		})
+	}
+}
+
+func TestRepairTraceparentRejects(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"garbage",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if repaired, ok := repairTraceparent(value); ok {
+			t.Fatalf("expected %q not to be repaired, got %q", value, repaired)
+		}
+	}
+}
+
+func TestPropagatorNames(t *testing.T) {
+	for _, tt := range []struct {
+		args     []string
+		expected []string
+	}{
+		{nil, []string{"w3c"}},
+		{[]string{"--otel-trace-propagator="}, []string{"w3c"}},
+		{[]string{"--otel-trace-propagator=b3, W3C ,ottrace,b3"}, []string{"b3", "w3c", "ottrace"}},
+		{[]string{"--otel-trace-propagator=jaeger,w3c"}, []string{"w3c"}},
+	} {
+		b := New("test")
+		cmd := newTestCommand(t, b, tt.args...)
+		if got := b.PropagatorNames(cmd); !reflect.DeepEqual(got, tt.expected) {
+			t.Fatalf("expected propagators %v for %v, got %v", tt.expected, tt.args, got)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package cobraotel
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jzelinskie/stringz"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// ExporterOptions are the resolved flag values used to construct a
+// SpanExporter.
+type ExporterOptions struct {
+	// Endpoint is the collector endpoint, if one was provided.
+	//
+	// It is normalized to a "host[:port]" optionally followed by a URL path;
+	// any scheme, query or fragment has been removed. gRPC targets with a
+	// "unix://" or "dns://" scheme, e.g. "unix:///var/run/otel.sock", are
+	// preserved as is.
+	Endpoint string
+
+	// URLPath overrides the URL path traces are exported to. It only applies
+	// to HTTP-based exporters.
+	URLPath string
+
+	// Insecure is true when the collector should be reached in plaintext.
+	Insecure bool
+
+	// Headers are sent with every export request.
+	Headers map[string]string
+
+	// UserAgent overrides the user agent the exporter identifies itself with.
+	// It is empty when the exporter's default should be used.
+	UserAgent string
+
+	// TLSConfig overrides the TLS configuration used to reach the collector.
+	// It is nil when the default configuration should be used.
+	TLSConfig *tls.Config
+}
+
+// ExporterFactory constructs a SpanExporter for a provider.
+type ExporterFactory func(ctx context.Context, opts ExporterOptions) (trace.SpanExporter, error)
+
+var builtinProviders = []string{"none", "otlphttp", "otlpgrpc", "otlp", "auto"}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ExporterFactory{}
+)
+
+// RegisterProvider makes a custom exporter available as a value for the
+// "$PREFIX-provider" flag.
+//
+// Names are case-insensitive, like the flag values selecting them. Built-in
+// provider names are reserved and cannot be overridden. Registering a name
+// twice replaces the previous factory.
+func RegisterProvider(name string, factory ExporterFactory) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return errors.New("cannot register provider: name is empty")
+	}
+	for _, builtin := range builtinProviders {
+		if name == builtin {
+			return fmt.Errorf("cannot register provider %q: name is reserved", name)
+		}
+	}
+	if factory == nil {
+		return fmt.Errorf("cannot register provider %q: factory is nil", name)
+	}
+
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+	return nil
+}
+
+// validateProviders returns an error for the first unknown provider of the
+// "+"-separated providers, quoting its raw value such that stray whitespace
+// or casing is visible.
+func validateProviders(provider string) error {
+	for _, raw := range strings.Split(provider, "+") {
+		name := strings.ToLower(strings.TrimSpace(raw))
+		if _, ok := registeredProvider(name); ok {
+			continue
+		}
+		// Empty providers and "jaeger" are reported by newExportersFromProviders.
+		if name == "" || name == "jaeger" || stringz.SliceContains(builtinProviders, name) {
+			continue
+		}
+		return fmt.Errorf("unknown tracing provider %q", raw)
+	}
+	return nil
+}
+
+func registeredProvider(name string) (ExporterFactory, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	factory, ok := providers[name]
+	return factory, ok
+}
+
+// providerNames returns the built-in providers followed by any registered
+// providers in lexical order.
+func providerNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	registered := make([]string, 0, len(providers))
+	for name := range providers {
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+
+	return append(append([]string{}, builtinProviders...), registered...)
+}
+
+// resolveProviders replaces the generic providers in the "+"-separated
+// provider with concrete ones:
+//   - "auto" is inferred from its endpoint by inferProvider
+//   - "otlp" is selected from the OTLP protocol by providerFromProtocol
+//
+// The endpoint is the raw endpoint, before normalizeEndpoints drops its
+// scheme. When it is empty, the OTEL_EXPORTER_OTLP_TRACES_ENDPOINT and
+// OTEL_EXPORTER_OTLP_ENDPOINT environment variables are inspected instead.
+func resolveProviders(provider, endpoint string) (string, error) {
+	providers := strings.Split(provider, "+")
+	endpoints := strings.Split(endpoint, "+")
+	for i, p := range providers {
+		switch strings.TrimSpace(p) {
+		case "auto":
+			group := endpoint
+			if len(endpoints) == len(providers) {
+				group = endpoints[i]
+			}
+			inferred, err := inferProviderFromEndpoints(group)
+			if err != nil {
+				return "", err
+			}
+			providers[i] = inferred
+		case "otlp":
+			selected, err := providerFromProtocol()
+			if err != nil {
+				return "", err
+			}
+			providers[i] = selected
+		}
+	}
+	return strings.Join(providers, "+"), nil
+}
+
+// providerFromProtocol selects the provider for the "otlp" provider from the
+// OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or else OTEL_EXPORTER_OTLP_PROTOCOL
+// environment variable: "grpc" (the default) selects "otlpgrpc" and
+// "http/protobuf" selects "otlphttp".
+func providerFromProtocol() (string, error) {
+	for _, envVar := range []string{"OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+		protocol := strings.TrimSpace(os.Getenv(envVar))
+		if protocol == "" {
+			continue
+		}
+		switch strings.ToLower(protocol) {
+		case "grpc":
+			return "otlpgrpc", nil
+		case "http/protobuf":
+			return "otlphttp", nil
+		default:
+			return "", fmt.Errorf("unsupported %s %q for the \"otlp\" tracing provider: expected \"grpc\" or \"http/protobuf\"", envVar, protocol)
+		}
+	}
+	return "otlpgrpc", nil
+}
+
+// inferProviderFromEndpoints infers the provider of the comma-separated
+// endpoints, which must all infer the same provider.
+func inferProviderFromEndpoints(endpoints string) (string, error) {
+	if strings.TrimSpace(endpoints) == "" {
+		for _, envVar := range []string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"} {
+			if value := os.Getenv(envVar); value != "" {
+				endpoints = value
+				break
+			}
+		}
+	}
+
+	var inferred string
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint == "" {
+			continue
+		}
+		provider, err := inferProvider(endpoint)
+		if err != nil {
+			return "", err
+		}
+		if inferred != "" && provider != inferred {
+			return "", fmt.Errorf("cannot infer the \"auto\" tracing provider: endpoints %q use both %s and %s", redactEndpoints(endpoints), inferred, provider)
+		}
+		inferred = provider
+	}
+	if inferred == "" {
+		return "", errors.New(`cannot infer the "auto" tracing provider without an endpoint`)
+	}
+	return inferred, nil
+}
+
+// inferProvider infers the provider for the "auto" provider from an
+// endpoint:
+//   - "grpc://" endpoints and gRPC targets such as "unix:///var/run/otel.sock"
+//     or "dns:///collector:4317" use "otlpgrpc"
+//   - "http://" and "https://" endpoints use "otlphttp"
+//   - other endpoints on port 4317, the default OTLP/gRPC port, use
+//     "otlpgrpc"
+//   - other endpoints on port 4318, the default OTLP/HTTP port, or with a
+//     URL path use "otlphttp"
+//
+// Any other endpoint is ambiguous and returns an error.
+func inferProvider(endpoint string) (string, error) {
+	if grpcTargetScheme(endpoint) != "" {
+		return "otlpgrpc", nil
+	}
+	if scheme, _, ok := strings.Cut(endpoint, "://"); ok {
+		switch strings.ToLower(scheme) {
+		case "grpc":
+			return "otlpgrpc", nil
+		case "http", "https":
+			return "otlphttp", nil
+		default:
+			return "", fmt.Errorf("cannot infer the \"auto\" tracing provider from endpoint %q: unknown scheme %q", redactEndpoint(endpoint), scheme)
+		}
+	}
+
+	normalized, err := normalizeEndpoint(endpoint)
+	if err != nil {
+		return "", err
+	}
+	host, path, _ := strings.Cut(normalized, "/")
+	if _, port, err := net.SplitHostPort(host); err == nil {
+		switch port {
+		case "4317":
+			return "otlpgrpc", nil
+		case "4318":
+			return "otlphttp", nil
+		}
+	}
+	if path != "" {
+		return "otlphttp", nil
+	}
+	return "", fmt.Errorf("cannot infer the \"auto\" tracing provider from endpoint %q: use a \"grpc://\" or \"http(s)://\" scheme, the default port of the protocol, or an explicit provider", redactEndpoint(endpoint))
+}
+
+// newExporter constructs the SpanExporter for the named provider.
+//
+// The returned exporter is nil for the "none" provider.
+//
+// If endpoint is not set, the clients are configured via the OpenTelemetry
+// environment variables or default values.
+// See: https://github.com/open-telemetry/opentelemetry-go/tree/main/exporters/otlp/otlptrace#environment-variables
+func newExporter(ctx context.Context, provider string, opts ExporterOptions) (trace.SpanExporter, error) {
+	if factory, ok := registeredProvider(provider); ok {
+		return factory(ctx, opts)
+	}
+
+	switch provider {
+	case "none":
+		return nil, nil
+	case "otlphttp":
+		if scheme := grpcTargetScheme(opts.Endpoint); scheme != "" {
+			return nil, fmt.Errorf(`%s:// endpoints are only supported by the "otlpgrpc" provider`, scheme)
+		}
+
+		var httpOpts []otlptracehttp.Option
+		host, path := splitEndpointPath(opts.Endpoint)
+		if host != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithEndpoint(host))
+		}
+		if opts.URLPath != "" {
+			path = opts.URLPath
+		}
+		if path != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithURLPath(path))
+		}
+		if len(opts.Headers) > 0 || opts.UserAgent != "" {
+			headers := make(map[string]string, len(opts.Headers)+1)
+			for k, v := range opts.Headers {
+				headers[k] = v
+			}
+			if opts.UserAgent != "" {
+				headers["User-Agent"] = opts.UserAgent
+			}
+			httpOpts = append(httpOpts, otlptracehttp.WithHeaders(headers))
+		}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		if opts.TLSConfig != nil {
+			httpOpts = append(httpOpts, otlptracehttp.WithTLSClientConfig(opts.TLSConfig))
+		}
+		return otlptrace.New(ctx, otlptracehttp.NewClient(httpOpts...))
+	case "otlpgrpc":
+		var grpcOpts []otlptracegrpc.Option
+		switch grpcTargetScheme(opts.Endpoint) {
+		case "unix":
+			grpcOpts = append(grpcOpts,
+				otlptracegrpc.WithEndpoint("passthrough:///"+opts.Endpoint[len("unix://"):]),
+				otlptracegrpc.WithDialOption(grpc.WithContextDialer(dialUnix), grpc.WithAuthority("localhost")),
+			)
+		case "dns":
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		default:
+			if host, _ := splitEndpointPath(opts.Endpoint); host != "" {
+				grpcOpts = append(grpcOpts, otlptracegrpc.WithEndpoint(host))
+			}
+		}
+		if opts.Insecure {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		}
+		if opts.TLSConfig != nil {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(opts.TLSConfig)))
+		}
+		if len(opts.Headers) > 0 {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithHeaders(opts.Headers))
+		}
+		if opts.UserAgent != "" {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithDialOption(grpc.WithUserAgent(opts.UserAgent)))
+		}
+		return otlptrace.New(ctx, otlptracegrpc.NewClient(grpcOpts...))
+	case "jaeger":
+		// The Jaeger exporter was deprecated upstream and removed from this
+		// package; it can still be provided by using RegisterProvider.
+		return nil, errors.New(`jaeger provider is no longer supported; use "otlphttp" or "otlpgrpc" instead`)
+	default:
+		return nil, fmt.Errorf("unknown tracing provider %q", provider)
+	}
+}
+
+// dialUnix connects to the unix socket at path.
+func dialUnix(ctx context.Context, path string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", path)
+}
+
+// splitEndpointPath splits a normalized endpoint into its host and URL path.
+func splitEndpointPath(endpoint string) (host, path string) {
+	if i := strings.IndexByte(endpoint, '/'); i >= 0 {
+		return endpoint[:i], endpoint[i:]
+	}
+	return endpoint, ""
+}
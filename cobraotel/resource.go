@@ -0,0 +1,312 @@
+package cobraotel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jzelinskie/cobrautil/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv117 "go.opentelemetry.io/otel/semconv/v1.17.0"
+	semconv121 "go.opentelemetry.io/otel/semconv/v1.21.0"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// semconvSchemaURLs are the schema URLs of the supported semantic convention
+// versions.
+//
+// The resource attributes set by this package use the same keys in all of
+// these versions, so only the schema URL of the resource changes.
+var semconvSchemaURLs = map[string]string{
+	"1.7.0":  semconv.SchemaURL,
+	"1.17.0": semconv117.SchemaURL,
+	"1.21.0": semconv121.SchemaURL,
+}
+
+// semconvSchemaURL returns the schema URL for a supported semantic
+// convention version, or an empty string if no version is provided.
+func semconvSchemaURL(version string) (string, error) {
+	if version == "" {
+		return "", nil
+	}
+	schemaURL, ok := semconvSchemaURLs[strings.TrimPrefix(version, "v")]
+	if !ok {
+		supported := make([]string, 0, len(semconvSchemaURLs))
+		for v := range semconvSchemaURLs {
+			supported = append(supported, v)
+		}
+		sort.Strings(supported)
+		return "", fmt.Errorf("unsupported semconv version %q (supported: %s)", version, strings.Join(supported, ", "))
+	}
+	return schemaURL, nil
+}
+
+const (
+	vcsRevisionKey      = attribute.Key("vcs.revision")
+	vcsTimeKey          = attribute.Key("vcs.time")
+	processStartTimeKey = attribute.Key("process.start_time")
+)
+
+// processStartTime approximates the start time of the process with the time
+// this package was initialized.
+var processStartTime = time.Now()
+
+// newResource builds the resource attached to every span.
+//
+// The resource is assembled from the following layers, where attributes in
+// later layers override attributes with the same key in earlier layers:
+//  1. attributes found by detectors configured with WithResourceDetectors
+//  2. defaults: the OpenTelemetry SDK, the binary's build info, the process
+//     start time and the default service name
+//  3. the environment: OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME
+//  4. flags: an explicitly provided service name, followed by the resource
+//     attributes provided to Configure
+//  5. attributes configured programmatically, with WithEnvAttributes followed
+//     by WithResourceJSON
+//  6. options provided to WithResourceOptions
+//
+// The service name is then sanitized with the func provided to
+// WithServiceNameSanitizer, if any. Overridden attributes are logged at the
+// pre-run level. The schema URL of the resource is replaced if a semconv
+// version was selected.
+func (b *Builder) newResource(ctx context.Context, cfg tracerConfig) (*resource.Resource, error) {
+	res := resource.Empty()
+	if len(b.detectors) > 0 {
+		detected, err := b.detectResource(ctx, resource.WithDetectors(b.detectors...))
+		if err != nil {
+			b.logger.Error(err, "failed to detect some resource attributes")
+		}
+		res = b.mergeResource(res, detected, "detectors")
+	}
+
+	defaultOpts := []resource.Option{resource.WithTelemetrySDK()}
+	if cfg.buildInfo {
+		defaultOpts = append(defaultOpts, resource.WithAttributes(buildInfoAttributes(debug.ReadBuildInfo())...))
+	}
+	if cfg.processStartTime {
+		defaultOpts = append(defaultOpts, resource.WithAttributes(processStartTimeAttributes(processStartTime)...))
+	}
+	if !cfg.serviceNameSet {
+		defaultOpts = append(defaultOpts, resource.WithAttributes(semconv.ServiceNameKey.String(cfg.serviceName)))
+	}
+	defaults, err := resource.New(ctx, defaultOpts...)
+	if err != nil {
+		return nil, err
+	}
+	res = b.mergeResource(res, defaults, "defaults")
+
+	env, err := resource.New(ctx, resource.WithFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	res = b.mergeResource(res, env, "environment")
+
+	if cfg.serviceNameSet {
+		res = b.mergeResource(res, resource.NewSchemaless(semconv.ServiceNameKey.String(cfg.serviceName)), "flags")
+	}
+	if len(cfg.resourceAttrs) > 0 {
+		res = b.mergeResource(res, resource.NewSchemaless(cfg.resourceAttrs...), "config")
+	}
+
+	if len(b.envAttrs) > 0 {
+		res = b.mergeResource(res, resource.NewSchemaless(envAttributes(b.envAttrs)...), "options")
+	}
+	if b.resourceJSON != nil {
+		attrs, err := parseResourceJSON(b.resourceJSON)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource JSON: %w", err)
+		}
+		res = b.mergeResource(res, resource.NewSchemaless(attrs...), "resource JSON")
+	}
+
+	if len(b.resourceOpts) > 0 {
+		custom, err := b.detectResource(ctx, b.resourceOpts...)
+		if err != nil {
+			b.logger.Error(err, "failed to apply some resource options")
+		}
+		res = b.mergeResource(res, custom, "resource options")
+	}
+
+	if b.serviceNameSanitizer != nil {
+		if value, ok := res.Set().Value(semconv.ServiceNameKey); ok {
+			if sanitized := b.serviceNameSanitizer(value.AsString()); sanitized != value.AsString() {
+				res = b.mergeResource(res, resource.NewSchemaless(semconv.ServiceNameKey.String(sanitized)), "service name sanitizer")
+			}
+		}
+	}
+
+	if cfg.schemaURL != "" {
+		res = resource.NewWithAttributes(cfg.schemaURL, res.Attributes()...)
+	}
+
+	return res, nil
+}
+
+// parseResourceJSON parses resource attributes from a JSON object, e.g.
+// {"deployment.environment":"prod"}, sorted by key.
+//
+// Numbers and booleans are coerced to strings; null values, arrays and
+// nested objects are rejected.
+func parseResourceJSON(data []byte) ([]attribute.KeyValue, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return nil, fmt.Errorf("expected a JSON object: %w", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON object")
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		if strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("empty attribute key")
+		}
+
+		var value string
+		switch v := values[k].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		case nil:
+			return nil, fmt.Errorf("attribute %q: null values are not supported", k)
+		default:
+			return nil, fmt.Errorf("attribute %q: expected a string, number or boolean, got %T", k, v)
+		}
+		attrs = append(attrs, attribute.String(k, value))
+	}
+	return attrs, nil
+}
+
+// detectResource creates a resource from options that may run detectors,
+// giving up once the timeout provided to WithResourceTimeout elapses.
+//
+// Detectors are not required to honor the context, so they are run in a
+// goroutine that is abandoned on timeout.
+func (b *Builder) detectResource(ctx context.Context, opts ...resource.Option) (*resource.Resource, error) {
+	if b.resourceTimeout <= 0 {
+		return resource.New(ctx, opts...)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.resourceTimeout)
+	defer cancel()
+
+	type result struct {
+		res *resource.Resource
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := resource.New(ctx, opts...)
+		done <- result{res, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("resource detection did not complete within %s: %w", b.resourceTimeout, ctx.Err())
+	}
+}
+
+// mergeResource merges the layer into the base resource, with the attributes
+// of the layer taking precedence.
+func (b *Builder) mergeResource(base, layer *resource.Resource, layerName string) *resource.Resource {
+	if layer == nil {
+		return base
+	}
+
+	baseAttrs := base.Set()
+	for _, attr := range layer.Attributes() {
+		if existing, ok := baseAttrs.Value(attr.Key); ok && existing != attr.Value {
+			b.logger.V(b.preRunLevel).Info(
+				"overriding resource attribute",
+				"key", attr.Key,
+				"previous", existing.Emit(),
+				"value", attr.Value.Emit(),
+				"source", layerName,
+			)
+		}
+	}
+
+	merged, err := resource.Merge(base, layer)
+	if err != nil {
+		// The schema URLs conflict: keep the attributes of both, but only the
+		// schema URL of the layer.
+		return resource.NewWithAttributes(layer.SchemaURL(), append(base.Attributes(), layer.Attributes()...)...)
+	}
+	return merged
+}
+
+// processStartTimeAttributes returns the process start time attribute,
+// formatted as RFC 3339 in UTC.
+//
+// The attribute is omitted when the start time is unknown.
+func processStartTimeAttributes(start time.Time) []attribute.KeyValue {
+	if start.IsZero() {
+		return nil
+	}
+	return []attribute.KeyValue{processStartTimeKey.String(start.UTC().Format(time.RFC3339Nano))}
+}
+
+// buildInfoAttributes returns the service version and VCS attributes found in
+// the provided build info.
+//
+// Settings that are absent, e.g. for stripped binaries or binaries built
+// outside of a VCS checkout, are omitted.
+func buildInfoAttributes(bi *debug.BuildInfo, ok bool) []attribute.KeyValue {
+	if !ok || bi == nil {
+		return nil
+	}
+
+	var attrs []attribute.KeyValue
+	if version := cobrautil.VersionWithFallbacks(bi); version != "" {
+		attrs = append(attrs, semconv.ServiceVersionKey.String(version))
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			attrs = append(attrs, vcsRevisionKey.String(setting.Value))
+		case "vcs.time":
+			attrs = append(attrs, vcsTimeKey.String(setting.Value))
+		}
+	}
+	return attrs
+}
+
+// envAttributes returns an attribute for every environment variable in the
+// provided map of attribute key to variable name that is set and non-empty.
+func envAttributes(envVars map[string]string) []attribute.KeyValue {
+	keys := make([]string, 0, len(envVars))
+	for key := range envVars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var attrs []attribute.KeyValue
+	for _, key := range keys {
+		if value := os.Getenv(envVars[key]); value != "" {
+			attrs = append(attrs, attribute.String(key, value))
+		}
+	}
+	return attrs
+}
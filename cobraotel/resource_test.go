@@ -0,0 +1,375 @@
+package cobraotel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+func TestBuildInfoAttributes(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Main: debug.Module{Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2023-01-02T03:04:05Z"},
+		},
+	}
+
+	set := attribute.NewSet(buildInfoAttributes(bi, true)...)
+	for key, expected := range map[attribute.Key]string{
+		semconv.ServiceVersionKey: "0123456789ab",
+		vcsRevisionKey:            "0123456789abcdef",
+		vcsTimeKey:                "2023-01-02T03:04:05Z",
+	} {
+		value, ok := set.Value(key)
+		if !ok || value.AsString() != expected {
+			t.Fatalf("expected %s=%q, got %q", key, expected, value.AsString())
+		}
+	}
+}
+
+func TestBuildInfoAttributesAbsent(t *testing.T) {
+	if attrs := buildInfoAttributes(nil, false); len(attrs) != 0 {
+		t.Fatalf("expected no attributes, got %v", attrs)
+	}
+
+	attrs := buildInfoAttributes(&debug.BuildInfo{}, true)
+	if len(attrs) != 0 {
+		t.Fatalf("expected no attributes for empty build info, got %v", attrs)
+	}
+}
+
+func TestProcessStartTimeAttributes(t *testing.T) {
+	start := time.Date(2023, 10, 1, 12, 30, 0, 500, time.FixedZone("EST", -5*60*60))
+	set := attribute.NewSet(processStartTimeAttributes(start)...)
+	value, ok := set.Value(processStartTimeKey)
+	if !ok {
+		t.Fatal("expected process.start_time attribute")
+	}
+	if expected := "2023-10-01T17:30:00.0000005Z"; value.AsString() != expected {
+		t.Fatalf("expected %q, got %q", expected, value.AsString())
+	}
+
+	if attrs := processStartTimeAttributes(time.Time{}); len(attrs) != 0 {
+		t.Fatalf("expected no attributes for an unknown start time, got %v", attrs)
+	}
+}
+
+func TestEnvAttributes(t *testing.T) {
+	t.Setenv("COBRAOTEL_TEST_POD_NAME", "pod-1234")
+	t.Setenv("COBRAOTEL_TEST_NODE_NAME", "")
+
+	attrs := envAttributes(map[string]string{
+		"k8s.pod.name":       "COBRAOTEL_TEST_POD_NAME",
+		"k8s.node.name":      "COBRAOTEL_TEST_NODE_NAME",
+		"k8s.namespace.name": "COBRAOTEL_TEST_UNSET",
+	})
+
+	if len(attrs) != 1 || attrs[0] != attribute.String("k8s.pod.name", "pod-1234") {
+		t.Fatalf("expected only k8s.pod.name to be set, got %v", attrs)
+	}
+}
+
+type stubDetector struct {
+	attrs []attribute.KeyValue
+	err   error
+}
+
+func (d stubDetector) Detect(context.Context) (*resource.Resource, error) {
+	return resource.NewSchemaless(d.attrs...), d.err
+}
+
+func TestResourceDetectors(t *testing.T) {
+	b := New("test", WithResourceDetectors(
+		stubDetector{attrs: []attribute.KeyValue{attribute.String("cloud.provider", "stub")}},
+		stubDetector{err: errors.New("metadata endpoint unavailable")},
+	))
+
+	res, err := b.newResource(context.Background(), tracerConfig{serviceName: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	set := res.Set()
+	if value, ok := set.Value("cloud.provider"); !ok || value.AsString() != "stub" {
+		t.Fatalf("expected detected attribute, got %v", res.Attributes())
+	}
+	if value, ok := set.Value(semconv.ServiceNameKey); !ok || value.AsString() != "test" {
+		t.Fatalf("expected service name to be preserved, got %v", res.Attributes())
+	}
+}
+
+// blockingDetector is a resource.Detector that hangs until unblocked,
+// ignoring its context like a detector stuck on a metadata endpoint.
+type blockingDetector struct {
+	unblock chan struct{}
+}
+
+func (d blockingDetector) Detect(context.Context) (*resource.Resource, error) {
+	<-d.unblock
+	return resource.NewSchemaless(attribute.String("cloud.provider", "slow")), nil
+}
+
+func TestWithResourceTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	b := New("test",
+		WithResourceDetectors(blockingDetector{unblock: unblock}),
+		WithResourceTimeout(10*time.Millisecond),
+	)
+	res, err := b.newResource(context.Background(), tracerConfig{serviceName: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	set := res.Set()
+	if _, ok := set.Value("cloud.provider"); ok {
+		t.Fatalf("expected the slow detector to be skipped, got %v", res.Attributes())
+	}
+	if value, _ := set.Value(semconv.ServiceNameKey); value.AsString() != "test" {
+		t.Fatalf("expected service name to be preserved, got %q", value.AsString())
+	}
+}
+
+func TestWithResourceOptions(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "team=from-env")
+
+	b := New("test", WithResourceOptions(
+		resource.WithAttributes(attribute.String("team", "from-option"), attribute.String("os.type", "plan9")),
+	))
+	res, err := b.newResource(context.Background(), tracerConfig{serviceName: "test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	set := res.Set()
+	if value, _ := set.Value("os.type"); value.AsString() != "plan9" {
+		t.Fatalf("expected os.type %q, got %q", "plan9", value.AsString())
+	}
+	if value, _ := set.Value("team"); value.AsString() != "from-option" {
+		t.Fatalf("expected resource options to override the environment, got team %q", value.AsString())
+	}
+	if value, _ := set.Value(semconv.ServiceNameKey); value.AsString() != "test" {
+		t.Fatalf("expected service name to be preserved, got %q", value.AsString())
+	}
+}
+
+func TestResourcePrecedence(t *testing.T) {
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "service.name=from-env,team=tracing")
+	t.Setenv("COBRAOTEL_TEST_TEAM", "from-option")
+
+	for _, tt := range []struct {
+		name            string
+		cfg             tracerConfig
+		envAttrs        map[string]string
+		expectedService string
+		expectedTeam    string
+	}{
+		{"env overrides default", tracerConfig{serviceName: "default"}, nil, "from-env", "tracing"},
+		{"flag overrides env", tracerConfig{serviceName: "from-flag", serviceNameSet: true}, nil, "from-flag", "tracing"},
+		{"option overrides env", tracerConfig{serviceName: "default"}, map[string]string{"team": "COBRAOTEL_TEST_TEAM"}, "from-env", "from-option"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", WithEnvAttributes(tt.envAttrs))
+			res, err := b.newResource(context.Background(), tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			set := res.Set()
+			if value, _ := set.Value(semconv.ServiceNameKey); value.AsString() != tt.expectedService {
+				t.Fatalf("expected service name %q, got %q", tt.expectedService, value.AsString())
+			}
+			if value, _ := set.Value("team"); value.AsString() != tt.expectedTeam {
+				t.Fatalf("expected team %q, got %q", tt.expectedTeam, value.AsString())
+			}
+		})
+	}
+}
+
+func TestResourceAccessor(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+	t.Setenv("COBRAOTEL_TEST_TEAM", "tracing")
+
+	b := New("test", WithEnvAttributes(map[string]string{"team": "COBRAOTEL_TEST_TEAM"}))
+	cmd := newTestCommand(t, b, "--otel-provider=none")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	if res := b.Resource(); res != nil {
+		t.Fatalf("expected no resource for the none provider, got %v", res.Attributes())
+	}
+
+	cmd = newTestCommand(t, b, "--otel-provider="+provider, "--otel-service-name=from-flag")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+	set := b.Resource().Set()
+	if value, _ := set.Value(semconv.ServiceNameKey); value.AsString() != "from-flag" {
+		t.Fatalf("expected service name %q, got %q", "from-flag", value.AsString())
+	}
+	if value, _ := set.Value("team"); value.AsString() != "tracing" {
+		t.Fatalf("expected team %q, got %q", "tracing", value.AsString())
+	}
+}
+
+func TestSemconvVersion(t *testing.T) {
+	for _, tt := range []struct {
+		version  string
+		expected string
+	}{
+		{"1.7.0", semconv.SchemaURL},
+		{"v1.17.0", "https://opentelemetry.io/schemas/1.17.0"},
+		{"1.21.0", "https://opentelemetry.io/schemas/1.21.0"},
+	} {
+		schemaURL, err := semconvSchemaURL(tt.version)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %s", tt.version, err)
+		}
+
+		res, err := New("test").newResource(context.Background(), tracerConfig{serviceName: "test", schemaURL: schemaURL})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if res.SchemaURL() != tt.expected {
+			t.Fatalf("expected schema URL %q for %q, got %q", tt.expected, tt.version, res.SchemaURL())
+		}
+		if value, _ := res.Set().Value(semconv.ServiceNameKey); value.AsString() != "test" {
+			t.Fatalf("expected service name to be preserved, got %q", value.AsString())
+		}
+	}
+
+	if _, err := semconvSchemaURL("1.0.0"); err == nil {
+		t.Fatal("expected error for an unsupported version")
+	}
+	if _, err := New("test").Configure(context.Background(), Config{SemconvVersion: "1.0.0"}); err == nil {
+		t.Fatal("expected Configure to reject an unsupported version")
+	}
+}
+
+func TestParseResourceJSON(t *testing.T) {
+	attrs, err := parseResourceJSON([]byte(`{"team":"tracing","replicas":3,"ratio":0.5,"canary":true}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []attribute.KeyValue{
+		attribute.String("canary", "true"),
+		attribute.String("ratio", "0.5"),
+		attribute.String("replicas", "3"),
+		attribute.String("team", "tracing"),
+	}
+	if len(attrs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, attrs)
+	}
+	for i := range expected {
+		if attrs[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, attrs)
+		}
+	}
+
+	for _, invalid := range []string{
+		``,
+		`["team"]`,
+		`{"team":"tracing"`,
+		`{"team":"tracing"} {}`,
+		`{"team":null}`,
+		`{"team":["a","b"]}`,
+		`{"team":{"name":"tracing"}}`,
+		`{"":"tracing"}`,
+	} {
+		if _, err := parseResourceJSON([]byte(invalid)); err == nil {
+			t.Fatalf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestResourceJSON(t *testing.T) {
+	provider, _ := registerInMemoryProvider(t)
+
+	path := filepath.Join(t.TempDir(), "resource.json")
+	if err := os.WriteFile(path, []byte(`{"team":"from-file","replicas":3}`), 0o600); err != nil {
+		t.Fatalf("failed to write resource file: %s", err)
+	}
+
+	for _, tt := range []struct {
+		name             string
+		flag             string
+		option           string
+		expectedTeam     string
+		expectedReplicas string
+	}{
+		{"inline flag", `--otel-resource-json={"team":"from-flag"}`, "", "from-flag", ""},
+		{"file flag", "--otel-resource-json=" + path, "", "from-file", "3"},
+		{"option overrides flag", "--otel-resource-json=" + path, `{"team":"from-option"}`, "from-option", "3"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []Option
+			if tt.option != "" {
+				opts = append(opts, WithResourceJSON([]byte(tt.option)))
+			}
+			b := New("test", opts...)
+			cmd := newTestCommand(t, b, "--otel-provider="+provider, tt.flag)
+			if err := b.RunE()(cmd, nil); err != nil {
+				t.Fatalf("RunE failed: %s", err)
+			}
+
+			set := b.Resource().Set()
+			if value, _ := set.Value("team"); value.AsString() != tt.expectedTeam {
+				t.Fatalf("expected team %q, got %q", tt.expectedTeam, value.AsString())
+			}
+			if value, _ := set.Value("replicas"); value.AsString() != tt.expectedReplicas {
+				t.Fatalf("expected replicas %q, got %q", tt.expectedReplicas, value.AsString())
+			}
+		})
+	}
+
+	b := New("test")
+	cmd := newTestCommand(t, b, "--otel-provider="+provider, `--otel-resource-json={"team":["a"]}`)
+	if err := b.RunE()(cmd, nil); err == nil {
+		t.Fatal("expected error for invalid resource JSON")
+	}
+
+	b = New("test", WithResourceJSON([]byte("team=tracing")))
+	cmd = newTestCommand(t, b, "--otel-provider="+provider)
+	if err := b.RunE()(cmd, nil); err == nil {
+		t.Fatal("expected error for invalid resource JSON option")
+	}
+}
+
+func TestWithServiceNameSanitizer(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	b := New("test", WithServiceNameSanitizer(func(name string) string {
+		return strings.ReplaceAll(strings.ToLower(name), " ", "-")
+	}))
+	cmd := newTestCommand(t, b,
+		"--otel-provider="+provider,
+		"--otel-service-name=Billing Worker",
+		"--otel-processor=simple",
+		"--otel-sample-ratio=1",
+	)
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	_, span := b.Tracer("test").Start(context.Background(), "span")
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if value, _ := spans[0].Resource.Set().Value(semconv.ServiceNameKey); value.AsString() != "billing-worker" {
+		t.Fatalf("expected sanitized service name %q, got %q", "billing-worker", value.AsString())
+	}
+}
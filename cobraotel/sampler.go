@@ -0,0 +1,439 @@
+package cobraotel
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jzelinskie/cobrautil/v2"
+	"github.com/jzelinskie/stringz"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// samplerNames are the values accepted by "$PREFIX-sampler".
+var samplerNames = []string{
+	"always_on",
+	"always_off",
+	"traceidratio",
+	"parentbased_always_on",
+	"parentbased_always_off",
+	"parentbased_traceidratio",
+}
+
+// samplerFromFlags returns the sampler used for traces.
+//
+// The sampler is resolved with the following precedence:
+//
+//	Source                          Used when
+//	------------------------------  ------------------------------------
+//	WithSampler                     provided; sampling flags are ignored
+//	"$PREFIX-sampler"               the flag is set
+//	OTEL_TRACES_SAMPLER             set, and no ratio-based flag is set
+//	ratio-based flags               otherwise, e.g. "$PREFIX-sample-ratio"
+//
+// "$PREFIX-sample-ratio" is also the argument of the "traceidratio" and
+// "parentbased_traceidratio" samplers. Explicitly setting any other
+// ratio-based flag alongside "$PREFIX-sampler" is an error.
+//
+// OTEL_TRACES_SAMPLER accepts the same sampler names as "$PREFIX-sampler",
+// with the ratio read from OTEL_TRACES_SAMPLER_ARG (defaulting to 1). The
+// samplers the SDK does not implement, such as "jaeger_remote", log a warning
+// and fall back to the ratio-based flags.
+//
+// Spans matching "$PREFIX-ignore-attributes" are dropped regardless of the
+// sampler.
+func (b *Builder) samplerFromFlags(cmd *cobra.Command) (trace.Sampler, error) {
+	sampler, err := b.baseSamplerFromFlags(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	ignoreAttributes := flagOrDefault(cmd, b.prefix("ignore-attributes"), nil, cobrautil.MustGetStringSlice)
+	if len(ignoreAttributes) > 0 {
+		attrs, err := parseAttributes(ignoreAttributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s: %w", b.prefix("ignore-attributes"), err)
+		}
+		sampler = newDropAttributesSampler(sampler, attrs)
+	}
+	return sampler, nil
+}
+
+func (b *Builder) baseSamplerFromFlags(cmd *cobra.Command) (trace.Sampler, error) {
+	if b.sampler != nil {
+		return b.sampler, nil
+	}
+
+	ratio := flagOrDefault(cmd, b.prefix("sample-ratio"), defaultSampleRatio, cobrautil.MustGetFloat64)
+
+	name := strings.ToLower(strings.TrimSpace(flagOrDefault(cmd, b.prefix("sampler"), "", cobrautil.MustGetString)))
+	if name != "" {
+		conflicting := ratioSamplerFlags
+		if !isRatioSampler(name) {
+			conflicting = append(conflicting, "sample-ratio")
+		}
+		for _, flag := range conflicting {
+			if cmd.Flags().Changed(b.prefix(flag)) {
+				return nil, fmt.Errorf("--%s cannot be used with --%s=%s", b.prefix(flag), b.prefix("sampler"), name)
+			}
+		}
+		return namedSampler(name, ratio)
+	}
+
+	if envName := b.samplerNameFromEnv(cmd); envName != "" {
+		if !stringz.SliceContains(unsupportedEnvSamplers, envName) {
+			return samplerFromEnv(envName)
+		}
+		b.logger.Info("WARNING: OTEL_TRACES_SAMPLER is not supported; using the default sampler", "sampler", envName)
+	}
+
+	rules, err := parseSamplingRules(flagOrDefault(cmd, b.prefix("sampling-rules"), nil, cobrautil.MustGetStringSlice))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %w", b.prefix("sampling-rules"), err)
+	}
+
+	return newSampler(samplerConfig{
+		ratio:        ratio,
+		ignoreParent: flagOrDefault(cmd, b.prefix("sampling-ignore-parent"), false, cobrautil.MustGetBool),
+		rules:        rules,
+		maxPerSecond: flagOrDefault(cmd, b.prefix("max-spans-per-second"), 0, cobrautil.MustGetInt),
+		now:          b.now,
+	}), nil
+}
+
+// ratioSamplerFlags are the flags configuring the default ratio-based
+// sampler, besides "$PREFIX-sample-ratio".
+var ratioSamplerFlags = []string{"sampling-ignore-parent", "sampling-rules", "max-spans-per-second"}
+
+// samplerNameFromEnv returns the sampler named by OTEL_TRACES_SAMPLER, which
+// takes precedence over the default ratio-based sampler unless one of its
+// flags was set, or an empty string if it does not apply.
+func (b *Builder) samplerNameFromEnv(cmd *cobra.Command) string {
+	for _, flag := range append([]string{"sample-ratio"}, ratioSamplerFlags...) {
+		if cmd.Flags().Changed(b.prefix(flag)) {
+			return ""
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER")))
+}
+
+// isRatioSampler returns whether the named sampler takes a ratio.
+func isRatioSampler(name string) bool {
+	return name == "traceidratio" || name == "parentbased_traceidratio"
+}
+
+// namedSampler returns the sampler for one of samplerNames.
+func namedSampler(name string, ratio float64) (trace.Sampler, error) {
+	switch name {
+	case "always_on":
+		return trace.AlwaysSample(), nil
+	case "always_off":
+		return trace.NeverSample(), nil
+	case "traceidratio":
+		return trace.TraceIDRatioBased(ratio), nil
+	case "parentbased_always_on":
+		return trace.ParentBased(trace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return trace.ParentBased(trace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return trace.ParentBased(trace.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, fmt.Errorf("unknown sampler: %s", name)
+	}
+}
+
+// unsupportedEnvSamplers are the values of OTEL_TRACES_SAMPLER defined by the
+// OpenTelemetry specification that the SDK does not implement, which fall
+// back to the default sampler like they do for the SDK.
+var unsupportedEnvSamplers = []string{"jaeger_remote", "parentbased_jaeger_remote", "xray"}
+
+// samplerFromEnv returns the sampler named by OTEL_TRACES_SAMPLER, using
+// OTEL_TRACES_SAMPLER_ARG as the ratio of ratio-based samplers.
+func samplerFromEnv(name string) (trace.Sampler, error) {
+	ratio := 1.0
+	if arg := strings.TrimSpace(os.Getenv("OTEL_TRACES_SAMPLER_ARG")); arg != "" && isRatioSampler(name) {
+		var err error
+		ratio, err = strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG: expected ratio between 0 and 1, got %q", arg)
+		}
+	}
+
+	sampler, err := namedSampler(name, ratio)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER: %w", err)
+	}
+	return sampler, nil
+}
+
+// samplerConfig holds the resolved values used to build a sampler.
+type samplerConfig struct {
+	ratio        float64
+	ignoreParent bool
+	rules        []samplingRule
+
+	// maxPerSecond caps the number of root spans sampled per second. Zero
+	// means there is no limit.
+	maxPerSecond int
+	now          func() time.Time
+}
+
+// newSampler returns the sampler used for the configured ratio.
+//
+// By default, the ratio only applies to root spans and the sampling decision
+// of a remote parent is honored so that traces are never partially recorded.
+// Services receiving untrusted traffic (e.g. public ingress) can ignore the
+// parent decision, which prevents clients from forcing every request to be
+// sampled and driving up the cost of storing traces.
+//
+// Sampling rules override the ratio for root spans with a matching name and
+// the rate limit applies to root spans after the ratio has been applied.
+func newSampler(cfg samplerConfig) trace.Sampler {
+	sampler := trace.TraceIDRatioBased(cfg.ratio)
+	if len(cfg.rules) > 0 {
+		sampler = newRulesSampler(cfg.rules, sampler)
+	}
+	if cfg.maxPerSecond > 0 {
+		sampler = newRateLimitingSampler(sampler, cfg.maxPerSecond, cfg.now)
+	}
+	if cfg.ignoreParent {
+		return sampler
+	}
+	return trace.ParentBased(sampler)
+}
+
+// samplingRule is the ratio used to sample spans with a given name.
+type samplingRule struct {
+	name  string
+	ratio float64
+}
+
+// parseSamplingRules parses a list of "name=ratio" pairs into sampling rules.
+func parseSamplingRules(pairs []string) ([]samplingRule, error) {
+	rules := make([]samplingRule, 0, len(pairs))
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("expected name=ratio, got %q", pair)
+		}
+		ratio, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("expected ratio between 0 and 1 for %q, got %q", name, value)
+		}
+		rules = append(rules, samplingRule{name: name, ratio: ratio})
+	}
+	return rules, nil
+}
+
+// rulesSampler samples spans by the ratio of the first rule matching the span
+// name and otherwise delegates to the fallback sampler.
+type rulesSampler struct {
+	names    []string
+	samplers []trace.Sampler
+	fallback trace.Sampler
+}
+
+func newRulesSampler(rules []samplingRule, fallback trace.Sampler) trace.Sampler {
+	s := rulesSampler{fallback: fallback}
+	for _, rule := range rules {
+		s.names = append(s.names, rule.name)
+		s.samplers = append(s.samplers, trace.TraceIDRatioBased(rule.ratio))
+	}
+	return s
+}
+
+func (s rulesSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	for i, name := range s.names {
+		if p.Name == name {
+			return s.samplers[i].ShouldSample(p)
+		}
+	}
+	return s.fallback.ShouldSample(p)
+}
+
+func (s rulesSampler) Description() string {
+	rules := make([]string, 0, len(s.names))
+	for i, name := range s.names {
+		rules = append(rules, name+":"+s.samplers[i].Description())
+	}
+	return fmt.Sprintf("Rules{%s}/%s", strings.Join(rules, ","), s.fallback.Description())
+}
+
+// rateLimitingSampler drops spans sampled by the wrapped sampler once more
+// than a fixed number of spans per second have been sampled.
+//
+// Only spans starting a trace in this process are limited: children of a
+// local span are left to the wrapped sampler, such that the limit never drops
+// part of a trace that was already sampled.
+//
+// It is implemented as a token bucket holding up to one second of spans, so
+// bursts up to the limit are sampled before spans are dropped.
+type rateLimitingSampler struct {
+	next trace.Sampler
+	rate float64
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimitingSampler(next trace.Sampler, perSecond int, now func() time.Time) trace.Sampler {
+	if now == nil {
+		now = time.Now
+	}
+	return &rateLimitingSampler{next: next, rate: float64(perSecond), now: now, tokens: float64(perSecond)}
+}
+
+func (s *rateLimitingSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	result := s.next.ShouldSample(p)
+	if result.Decision != trace.RecordAndSample {
+		return result
+	}
+	if parent := oteltrace.SpanContextFromContext(p.ParentContext); parent.IsValid() && !parent.IsRemote() {
+		return result
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if !s.last.IsZero() {
+		s.tokens = math.Min(s.rate, s.tokens+now.Sub(s.last).Seconds()*s.rate)
+	}
+	s.last = now
+
+	if s.tokens < 1 {
+		return trace.SamplingResult{
+			Decision:   trace.Drop,
+			Tracestate: oteltrace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	s.tokens--
+	return result
+}
+
+func (s *rateLimitingSampler) Description() string {
+	return fmt.Sprintf("RateLimiting{%g/s}/%s", s.rate, s.next.Description())
+}
+
+// parseAttributes parses a list of "key=value" pairs into attributes.
+func parseAttributes(pairs []string) ([]attribute.KeyValue, error) {
+	attrs := make([]attribute.KeyValue, 0, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		attrs = append(attrs, attribute.String(key, strings.TrimSpace(value)))
+	}
+	return attrs, nil
+}
+
+// dropAttributesSampler drops any span started with one of the provided
+// attributes and otherwise delegates to the wrapped sampler.
+//
+// Attribute values are compared using their string representation.
+type dropAttributesSampler struct {
+	next  trace.Sampler
+	attrs []attribute.KeyValue
+}
+
+func newDropAttributesSampler(next trace.Sampler, attrs []attribute.KeyValue) trace.Sampler {
+	return dropAttributesSampler{next: next, attrs: attrs}
+}
+
+func (s dropAttributesSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	for _, attr := range p.Attributes {
+		for _, drop := range s.attrs {
+			if attr.Key == drop.Key && attr.Value.Emit() == drop.Value.AsString() {
+				return trace.SamplingResult{
+					Decision:   trace.Drop,
+					Tracestate: oteltrace.SpanContextFromContext(p.ParentContext).TraceState(),
+				}
+			}
+		}
+	}
+	return s.next.ShouldSample(p)
+}
+
+func (s dropAttributesSampler) Description() string {
+	pairs := make([]string, 0, len(s.attrs))
+	for _, attr := range s.attrs {
+		pairs = append(pairs, string(attr.Key)+"="+attr.Value.AsString())
+	}
+	return fmt.Sprintf("DropAttributes{%s}/%s", strings.Join(pairs, ","), s.next.Description())
+}
+
+// dynamicSampler is a Sampler delegating sampling decisions to a function.
+type dynamicSampler func(trace.SamplingParameters) trace.SamplingResult
+
+func (s dynamicSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	return s(p)
+}
+
+func (dynamicSampler) Description() string {
+	return "DynamicSampler"
+}
+
+// traceStateSampler adds a member to the tracestate of root spans sampled by
+// the wrapped sampler; see WithTraceState.
+//
+// Samplers are the only extension point of the SDK able to set the
+// tracestate of a span: span processors observe spans once their span
+// context is already immutable.
+type traceStateSampler struct {
+	trace.Sampler
+	key, value string
+}
+
+func (s traceStateSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	result := s.Sampler.ShouldSample(p)
+	if oteltrace.SpanContextFromContext(p.ParentContext).IsValid() {
+		return result
+	}
+	if ts, err := result.Tracestate.Insert(s.key, s.value); err == nil {
+		result.Tracestate = ts
+	}
+	return result
+}
+
+// recordDroppedRoots wraps sampler in a recordRootSampler below the samplers
+// whose decisions to drop are deliberate, such that spans matching
+// "$PREFIX-ignore-attributes" and spans dropped by the "always_off" and
+// "parentbased_always_off" samplers stay dropped however slow they are.
+func recordDroppedRoots(sampler trace.Sampler) trace.Sampler {
+	if s, ok := sampler.(dropAttributesSampler); ok {
+		s.next = recordDroppedRoots(s.next)
+		return s
+	}
+	switch sampler.Description() {
+	case trace.NeverSample().Description(), trace.ParentBased(trace.NeverSample()).Description():
+		return sampler
+	}
+	return recordRootSampler{Sampler: sampler}
+}
+
+// recordRootSampler records the root spans dropped by the wrapped sampler
+// without sampling them, such that keepSlowSpanProcessor can export the slow
+// ones.
+type recordRootSampler struct {
+	trace.Sampler
+}
+
+func (s recordRootSampler) ShouldSample(p trace.SamplingParameters) trace.SamplingResult {
+	result := s.Sampler.ShouldSample(p)
+	if result.Decision == trace.Drop && !oteltrace.SpanContextFromContext(p.ParentContext).IsValid() {
+		result.Decision = trace.RecordOnly
+	}
+	return result
+}
@@ -0,0 +1,374 @@
+package cobraotel
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestDropAttributesSampler(t *testing.T) {
+	attrs, err := parseAttributes([]string{"http.target=/healthz", "http.target=/readyz"})
+	if err != nil {
+		t.Fatalf("failed to parse attributes: %s", err)
+	}
+	sampler := newDropAttributesSampler(trace.AlwaysSample(), attrs)
+
+	for _, tt := range []struct {
+		name     string
+		attrs    []attribute.KeyValue
+		expected trace.SamplingDecision
+	}{
+		{"no attributes", nil, trace.RecordAndSample},
+		{"healthz", []attribute.KeyValue{attribute.String("http.target", "/healthz")}, trace.Drop},
+		{"readyz", []attribute.KeyValue{attribute.String("http.target", "/readyz")}, trace.Drop},
+		{"other target", []attribute.KeyValue{attribute.String("http.target", "/api")}, trace.RecordAndSample},
+		{"other key", []attribute.KeyValue{attribute.String("http.route", "/healthz")}, trace.RecordAndSample},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result := sampler.ShouldSample(trace.SamplingParameters{
+				ParentContext: context.Background(),
+				Name:          "span",
+				Attributes:    tt.attrs,
+			})
+			if result.Decision != tt.expected {
+				t.Fatalf("expected decision %v, got %v", tt.expected, result.Decision)
+			}
+		})
+	}
+}
+
+func TestParseAttributesInvalid(t *testing.T) {
+	if _, err := parseAttributes([]string{"http.target"}); err == nil {
+		t.Fatal("expected error for missing value")
+	}
+	if _, err := parseAttributes([]string{"=/healthz"}); err == nil {
+		t.Fatal("expected error for missing key")
+	}
+}
+
+func TestRulesSampler(t *testing.T) {
+	rules, err := parseSamplingRules([]string{"checkout=1", "list=0", "checkout=0"})
+	if err != nil {
+		t.Fatalf("failed to parse rules: %s", err)
+	}
+	sampler := newSampler(samplerConfig{ratio: 0, rules: rules})
+
+	for _, tt := range []struct {
+		name     string
+		expected trace.SamplingDecision
+	}{
+		{"checkout", trace.RecordAndSample}, // first matching rule wins
+		{"list", trace.Drop},
+		{"other", trace.Drop}, // falls back to the sample ratio
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			result := sampler.ShouldSample(trace.SamplingParameters{
+				ParentContext: context.Background(),
+				Name:          tt.name,
+			})
+			if result.Decision != tt.expected {
+				t.Fatalf("expected decision %v, got %v", tt.expected, result.Decision)
+			}
+		})
+	}
+
+	fallback := newSampler(samplerConfig{ratio: 1, rules: rules})
+	result := fallback.ShouldSample(trace.SamplingParameters{ParentContext: context.Background(), Name: "other"})
+	if result.Decision != trace.RecordAndSample {
+		t.Fatalf("expected fallback ratio to sample, got %v", result.Decision)
+	}
+}
+
+func TestParseSamplingRulesInvalid(t *testing.T) {
+	for _, rule := range []string{"checkout", "=1", "checkout=fast", "checkout=2"} {
+		if _, err := parseSamplingRules([]string{rule}); err == nil {
+			t.Fatalf("expected error parsing %q", rule)
+		}
+	}
+}
+
+func TestRateLimitingSampler(t *testing.T) {
+	now := time.Unix(0, 0)
+	sampler := newSampler(samplerConfig{ratio: 1, maxPerSecond: 10, now: func() time.Time { return now }})
+
+	sampled := func(n int) (count int) {
+		for i := 0; i < n; i++ {
+			result := sampler.ShouldSample(trace.SamplingParameters{ParentContext: context.Background(), Name: "span"})
+			if result.Decision == trace.RecordAndSample {
+				count++
+			}
+		}
+		return count
+	}
+
+	// A burst is capped at one second's worth of spans.
+	if got := sampled(100); got != 10 {
+		t.Fatalf("expected burst to be capped at 10 spans, got %d", got)
+	}
+
+	// At steady state, spans are sampled at the configured rate.
+	for i := 0; i < 5; i++ {
+		now = now.Add(100 * time.Millisecond)
+		if got := sampled(5); got != 1 {
+			t.Fatalf("expected 1 span per 100ms, got %d", got)
+		}
+	}
+
+	// Tokens never accumulate beyond one second's worth.
+	now = now.Add(time.Minute)
+	if got := sampled(100); got != 10 {
+		t.Fatalf("expected burst after idle period to be capped at 10 spans, got %d", got)
+	}
+}
+
+func TestRateLimitingSamplerHonorsParent(t *testing.T) {
+	sampler := newSampler(samplerConfig{ratio: 1, maxPerSecond: 1})
+
+	parent := oteltrace.ContextWithRemoteSpanContext(context.Background(), oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{1},
+		SpanID:     oteltrace.SpanID{1},
+		TraceFlags: oteltrace.FlagsSampled,
+		Remote:     true,
+	}))
+	for i := 0; i < 10; i++ {
+		result := sampler.ShouldSample(trace.SamplingParameters{ParentContext: parent, TraceID: oteltrace.TraceID{1}, Name: "span"})
+		if result.Decision != trace.RecordAndSample {
+			t.Fatalf("expected sampled parent to be honored, got %v", result.Decision)
+		}
+	}
+}
+
+func TestRateLimitingSamplerIgnoringParent(t *testing.T) {
+	sampler := newSampler(samplerConfig{ratio: 1, ignoreParent: true, maxPerSecond: 1, now: func() time.Time { return time.Unix(0, 0) }})
+
+	root := sampler.ShouldSample(trace.SamplingParameters{ParentContext: context.Background(), TraceID: oteltrace.TraceID{1}, Name: "root"})
+	if root.Decision != trace.RecordAndSample {
+		t.Fatalf("expected the first root span to be sampled, got %v", root.Decision)
+	}
+	if result := sampler.ShouldSample(trace.SamplingParameters{ParentContext: context.Background(), TraceID: oteltrace.TraceID{2}, Name: "root"}); result.Decision != trace.Drop {
+		t.Fatalf("expected root spans over the limit to be dropped, got %v", result.Decision)
+	}
+
+	parent := oteltrace.ContextWithSpanContext(context.Background(), oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{1},
+		SpanID:     oteltrace.SpanID{1},
+		TraceFlags: oteltrace.FlagsSampled,
+	}))
+	for i := 0; i < 10; i++ {
+		result := sampler.ShouldSample(trace.SamplingParameters{ParentContext: parent, TraceID: oteltrace.TraceID{1}, Name: "child"})
+		if result.Decision != trace.RecordAndSample {
+			t.Fatalf("expected children of a sampled parent not to be rate limited, got %v", result.Decision)
+		}
+	}
+}
+
+func TestSamplerFromFlags(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		opts     []Option
+		args     []string
+		expected string
+	}{
+		{"default", nil, nil, trace.ParentBased(trace.TraceIDRatioBased(defaultSampleRatio)).Description()},
+		{"ratio", nil, []string{"--otel-sample-ratio=0.5"}, trace.ParentBased(trace.TraceIDRatioBased(0.5)).Description()},
+		{"flag", nil, []string{"--otel-sampler=always_off"}, trace.NeverSample().Description()},
+		{"flag with ratio", nil, []string{"--otel-sampler=parentbased_traceidratio", "--otel-sample-ratio=0.5"}, trace.ParentBased(trace.TraceIDRatioBased(0.5)).Description()},
+		{"option", []Option{WithSampler(trace.AlwaysSample())}, []string{"--otel-sampler=always_off", "--otel-sample-ratio=0.5"}, trace.AlwaysSample().Description()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", tt.opts...)
+			cmd := newTestCommand(t, b, tt.args...)
+			sampler, err := b.samplerFromFlags(cmd)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := sampler.Description(); got != tt.expected {
+				t.Fatalf("expected sampler %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSamplerFromEnv(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		sampler    string
+		samplerArg string
+		args       []string
+		expected   string
+	}{
+		{"name", "always_on", "", nil, trace.AlwaysSample().Description()},
+		{"ratio", "traceidratio", "0.25", nil, trace.TraceIDRatioBased(0.25).Description()},
+		{"default ratio", "parentbased_traceidratio", "", nil, trace.ParentBased(trace.TraceIDRatioBased(1)).Description()},
+		{"argument ignored", "parentbased_always_off", "0.25", nil, trace.ParentBased(trace.NeverSample()).Description()},
+		{"sampler flag overrides env", "always_on", "", []string{"--otel-sampler=always_off"}, trace.NeverSample().Description()},
+		{"ratio flag overrides env", "always_on", "", []string{"--otel-sample-ratio=0.5"}, trace.ParentBased(trace.TraceIDRatioBased(0.5)).Description()},
+		{"unsupported", "jaeger_remote", "endpoint=http://localhost:14250", nil, trace.ParentBased(trace.TraceIDRatioBased(defaultSampleRatio)).Description()},
+		{"unsupported parent based", "parentbased_jaeger_remote", "", nil, trace.ParentBased(trace.TraceIDRatioBased(defaultSampleRatio)).Description()},
+		{"unsupported xray", "xray", "", nil, trace.ParentBased(trace.TraceIDRatioBased(defaultSampleRatio)).Description()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_TRACES_SAMPLER", tt.sampler)
+			t.Setenv("OTEL_TRACES_SAMPLER_ARG", tt.samplerArg)
+
+			b := New("test")
+			cmd := newTestCommand(t, b, tt.args...)
+			sampler, err := b.samplerFromFlags(cmd)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got := sampler.Description(); got != tt.expected {
+				t.Fatalf("expected sampler %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSamplerFromEnvInvalid(t *testing.T) {
+	for _, env := range [][2]string{
+		{"sometimes", ""},
+		{"traceidratio", "half"},
+		{"traceidratio", "2"},
+	} {
+		t.Setenv("OTEL_TRACES_SAMPLER", env[0])
+		t.Setenv("OTEL_TRACES_SAMPLER_ARG", env[1])
+
+		b := New("test")
+		cmd := newTestCommand(t, b)
+		if _, err := b.samplerFromFlags(cmd); err == nil {
+			t.Fatalf("expected error for %v", env)
+		}
+	}
+}
+
+func TestSamplerFromFlagsConflicts(t *testing.T) {
+	for _, args := range [][]string{
+		{"--otel-sampler=always_off", "--otel-sample-ratio=0.5"},
+		{"--otel-sampler=always_on", "--otel-sampling-ignore-parent"},
+		{"--otel-sampler=traceidratio", "--otel-sampling-rules=checkout=1"},
+		{"--otel-sampler=parentbased_always_on", "--otel-max-spans-per-second=10"},
+		{"--otel-sampler=sometimes"},
+	} {
+		b := New("test")
+		cmd := newTestCommand(t, b, args...)
+		if _, err := b.samplerFromFlags(cmd); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
+
+func TestDefaultSamplerHonorsParent(t *testing.T) {
+	parent := func(flags oteltrace.TraceFlags) context.Context {
+		return oteltrace.ContextWithRemoteSpanContext(context.Background(), oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+			TraceID:    oteltrace.TraceID{0xff},
+			SpanID:     oteltrace.SpanID{1},
+			TraceFlags: flags,
+			Remote:     true,
+		}))
+	}
+
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		parent   context.Context
+		expected trace.SamplingDecision
+	}{
+		{"default sampled parent", nil, parent(oteltrace.FlagsSampled), trace.RecordAndSample},
+		{"default unsampled parent", []string{"--otel-sample-ratio=1"}, parent(0), trace.Drop},
+		{"always_on unsampled parent", []string{"--otel-sampler=always_on"}, parent(0), trace.RecordAndSample},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test")
+			cmd := newTestCommand(t, b, tt.args...)
+			sampler, err := b.samplerFromFlags(cmd)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			result := sampler.ShouldSample(trace.SamplingParameters{ParentContext: tt.parent, TraceID: oteltrace.TraceID{0xff}, Name: "span"})
+			if result.Decision != tt.expected {
+				t.Fatalf("expected decision %v, got %v", tt.expected, result.Decision)
+			}
+		})
+	}
+}
+
+func TestWithDynamicSampler(t *testing.T) {
+	var enabled atomic.Bool
+	b := New("test", WithDynamicSampler(func(p trace.SamplingParameters) trace.SamplingResult {
+		if enabled.Load() {
+			return trace.SamplingResult{Decision: trace.RecordAndSample}
+		}
+		return trace.SamplingResult{Decision: trace.Drop}
+	}))
+	cmd := newTestCommand(t, b, "--otel-sample-ratio=1")
+	sampler, err := b.samplerFromFlags(cmd)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	params := trace.SamplingParameters{ParentContext: context.Background(), Name: "span"}
+	if result := sampler.ShouldSample(params); result.Decision != trace.Drop {
+		t.Fatalf("expected span to be dropped, got %v", result.Decision)
+	}
+	enabled.Store(true)
+	if result := sampler.ShouldSample(params); result.Decision != trace.RecordAndSample {
+		t.Fatalf("expected span to be sampled, got %v", result.Decision)
+	}
+}
+
+func TestWithTraceState(t *testing.T) {
+	provider, exporter := registerInMemoryProvider(t)
+
+	b := New("test", WithTraceState("vendor@acme", "route:eu"))
+	cmd := newTestCommand(t, b, "--otel-provider="+provider, "--otel-processor=simple", "--otel-sample-ratio=1")
+	if err := b.RunE()(cmd, nil); err != nil {
+		t.Fatalf("RunE failed: %s", err)
+	}
+
+	ctx, root := b.Tracer("test").Start(context.Background(), "root")
+	_, child := b.Tracer("test").Start(ctx, "child")
+	child.End()
+	root.End()
+	for _, span := range exporter.GetSpans() {
+		if got := span.SpanContext.TraceState().Get("vendor@acme"); got != "route:eu" {
+			t.Fatalf("expected span %q to have the tracestate member, got %q", span.Name, got)
+		}
+	}
+
+	// Remote parents keep their own tracestate.
+	parentState, err := oteltrace.ParseTraceState("other=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{1},
+		SpanID:     oteltrace.SpanID{1},
+		TraceFlags: oteltrace.FlagsSampled,
+		TraceState: parentState,
+		Remote:     true,
+	})
+	_, span := b.Tracer("test").Start(oteltrace.ContextWithRemoteSpanContext(context.Background(), parent), "remote child")
+	if ts := span.SpanContext().TraceState(); ts.String() != "other=1" {
+		t.Fatalf("expected the tracestate of the remote parent, got %q", ts)
+	}
+	span.End()
+
+	for _, member := range [][2]string{
+		{"", "value"},
+		{"Upper", "value"},
+		{"key", "has,comma"},
+		{"key", "has=equals"},
+		{"key", "trailing space "},
+	} {
+		b := New("test", WithTraceState(member[0], member[1]))
+		cmd := newTestCommand(t, b, "--otel-provider="+provider)
+		if err := b.RunE()(cmd, nil); err == nil {
+			t.Fatalf("expected an error for tracestate member %q=%q", member[0], member[1])
+		}
+	}
+}
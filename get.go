@@ -0,0 +1,72 @@
+package cobrautil
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// GetBool returns the bool value of a flag with the given name, or an error if
+// that flag was never defined or is not a bool.
+func GetBool(cmd *cobra.Command, name string) (bool, error) {
+	value, err := cmd.Flags().GetBool(name)
+	return value, flagError(name, err)
+}
+
+// GetDuration returns the time.Duration value of a flag with the given name,
+// or an error if that flag was never defined or is not a duration.
+func GetDuration(cmd *cobra.Command, name string) (time.Duration, error) {
+	value, err := cmd.Flags().GetDuration(name)
+	return value, flagError(name, err)
+}
+
+// GetFloat64 returns the float64 value of a flag with the given name, or an
+// error if that flag was never defined or is not a float64.
+func GetFloat64(cmd *cobra.Command, name string) (float64, error) {
+	value, err := cmd.Flags().GetFloat64(name)
+	return value, flagError(name, err)
+}
+
+// GetInt returns the int value of a flag with the given name, or an error if
+// that flag was never defined or is not an int.
+func GetInt(cmd *cobra.Command, name string) (int, error) {
+	value, err := cmd.Flags().GetInt(name)
+	return value, flagError(name, err)
+}
+
+// GetInt64 returns the int64 value of a flag with the given name, or an error
+// if that flag was never defined or is not an int64.
+func GetInt64(cmd *cobra.Command, name string) (int64, error) {
+	value, err := cmd.Flags().GetInt64(name)
+	return value, flagError(name, err)
+}
+
+// GetString returns the string value of a flag with the given name, or an
+// error if that flag was never defined or is not a string.
+func GetString(cmd *cobra.Command, name string) (string, error) {
+	value, err := cmd.Flags().GetString(name)
+	return value, flagError(name, err)
+}
+
+// GetStringSlice returns the []string value of a flag with the given name, or
+// an error if that flag was never defined or is not a string slice.
+func GetStringSlice(cmd *cobra.Command, name string) ([]string, error) {
+	value, err := cmd.Flags().GetStringSlice(name)
+	return value, flagError(name, err)
+}
+
+// GetStringToString returns the map[string]string value of a flag with the
+// given name, or an error if that flag was never defined or is not a
+// map[string]string.
+func GetStringToString(cmd *cobra.Command, name string) (map[string]string, error) {
+	value, err := cmd.Flags().GetStringToString(name)
+	return value, flagError(name, err)
+}
+
+func flagError(name string, err error) error {
+	if err != nil {
+		return fmt.Errorf("failed to get cobra flag %q: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package cobrautil_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jzelinskie/cobrautil/v2"
+)
+
+func TestGetters(t *testing.T) {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().Bool("enabled", false, "")
+	cmd.Flags().Duration("timeout", 0, "")
+	cmd.Flags().Float64("ratio", 0, "")
+	cmd.Flags().Int("count", 0, "")
+	cmd.Flags().Int64("size", 0, "")
+	cmd.Flags().String("name", "", "")
+	cmd.Flags().StringSlice("tags", nil, "")
+	cmd.Flags().StringToString("headers", nil, "")
+	if err := cmd.Flags().Parse([]string{
+		"--enabled",
+		"--timeout=5s",
+		"--ratio=0.5",
+		"--count=42",
+		"--size=8589934592",
+		"--name=test",
+		"--tags=a,b",
+		"--headers=key=value",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		get      func(name string) (any, error)
+		flag     string
+		expected any
+	}{
+		{"bool", func(name string) (any, error) { return cobrautil.GetBool(cmd, name) }, "enabled", true},
+		{"duration", func(name string) (any, error) { return cobrautil.GetDuration(cmd, name) }, "timeout", 5 * time.Second},
+		{"float64", func(name string) (any, error) { return cobrautil.GetFloat64(cmd, name) }, "ratio", 0.5},
+		{"int", func(name string) (any, error) { return cobrautil.GetInt(cmd, name) }, "count", 42},
+		{"int64", func(name string) (any, error) { return cobrautil.GetInt64(cmd, name) }, "size", int64(8589934592)},
+		{"string", func(name string) (any, error) { return cobrautil.GetString(cmd, name) }, "name", "test"},
+		{"string slice", func(name string) (any, error) { return cobrautil.GetStringSlice(cmd, name) }, "tags", []string{"a", "b"}},
+		{"string to string", func(name string) (any, error) { return cobrautil.GetStringToString(cmd, name) }, "headers", map[string]string{"key": "value"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.get(tt.flag)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, got)
+			}
+
+			if _, err := tt.get("missing"); err == nil {
+				t.Fatal("expected error for an undefined flag")
+			}
+
+			wrongType := "name"
+			if tt.flag == "name" {
+				wrongType = "count"
+			}
+			if _, err := tt.get(wrongType); err == nil {
+				t.Fatalf("expected error for flag %q of the wrong type", wrongType)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package cobrautil
 
 import (
+	"fmt"
 	"net"
 	"os"
 	"time"
@@ -165,11 +166,11 @@ func MustGetIPv4Mask(cmd *cobra.Command, name string) net.IPMask {
 }
 
 // MustGetInt returns the int value of a flag with the given name and panics if
-// that flag was never defined.
+// that flag was never defined or is not an int flag.
 func MustGetInt(cmd *cobra.Command, name string) int {
 	value, err := cmd.Flags().GetInt(name)
 	if err != nil {
-		panic("failed to find cobra flag: " + name)
+		panic(fmt.Sprintf("failed to get int cobra flag %q: %s", name, err))
 	}
 	return value
 }
@@ -205,11 +206,11 @@ func MustGetInt32Slice(cmd *cobra.Command, name string) []int32 {
 }
 
 // MustGetInt64 returns the int64 value of a flag with the given name and panics
-// if that flag was never defined.
+// if that flag was never defined or is not an int64 flag.
 func MustGetInt64(cmd *cobra.Command, name string) int64 {
 	value, err := cmd.Flags().GetInt64(name)
 	if err != nil {
-		panic("failed to find cobra flag: " + name)
+		panic(fmt.Sprintf("failed to get int64 cobra flag %q: %s", name, err))
 	}
 	return value
 }
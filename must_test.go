@@ -0,0 +1,52 @@
+package cobrautil_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jzelinskie/cobrautil/v2"
+)
+
+func expectPanic(t *testing.T, expected string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if r == nil {
+			t.Fatal("expected panic")
+		}
+		if msg, _ := r.(string); !strings.Contains(msg, expected) {
+			t.Fatalf("expected panic %q to contain %q", r, expected)
+		}
+	}()
+	fn()
+}
+
+func TestMustGetInt(t *testing.T) {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().Int("count", 0, "")
+	if err := cmd.Flags().Parse([]string{"--count=42"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cobrautil.MustGetInt(cmd, "count"); got != 42 {
+		t.Fatalf("expected 42, got %d", got)
+	}
+	expectPanic(t, `int cobra flag "missing"`, func() { cobrautil.MustGetInt(cmd, "missing") })
+}
+
+func TestMustGetInt64(t *testing.T) {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().Int64("size", 0, "")
+	if err := cmd.Flags().Parse([]string{"--size=8589934592"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cobrautil.MustGetInt64(cmd, "size"); got != 8589934592 {
+		t.Fatalf("expected 8589934592, got %d", got)
+	}
+	expectPanic(t, `int64 cobra flag "missing"`, func() { cobrautil.MustGetInt64(cmd, "missing") })
+	expectPanic(t, "of type int64", func() { cobrautil.MustGetInt(cmd, "size") })
+}